@@ -110,8 +110,17 @@ func main() {
 		log.Fatalf("Failed to unmarshal OSImageDownloadQueryParams: %v\n", err)
 	}
 
+	reg := prometheus.NewRegistry()
+
+	editor := isoeditor.NewEditor(Options.DataDir, isoeditor.WithMetricsRegisterer(reg))
+	defer func() {
+		if err := editor.Close(); err != nil {
+			log.WithError(err).Error("Failed to clean up the ISO editor")
+		}
+	}()
+
 	is, err := imagestore.NewImageStore(
-		isoeditor.NewEditor(Options.DataDir),
+		editor,
 		Options.DataDir,
 		Options.ImageServiceBaseURL,
 		Options.InsecureSkipVerify,
@@ -134,7 +143,6 @@ func main() {
 		readinessHandler.Enable()
 	}()
 
-	reg := prometheus.NewRegistry()
 	metricsConfig := metrics.Config{
 		Registry:        reg,
 		Prefix:          "assisted_image_service",
@@ -171,7 +171,7 @@ var _ = Context("with a data directory configured", func() {
 				Expect(err).NotTo(HaveOccurred())
 
 				rootfs := fmt.Sprintf(rootfsURL, version["openshift_version"])
-				mockEditor.EXPECT().CreateMinimalISOTemplate(gomock.Any(), rootfs, "x86_64", gomock.Any()).Return(nil)
+				mockEditor.EXPECT().CreateMinimalISOTemplate(gomock.Any(), gomock.Any(), rootfs, "x86_64", gomock.Any()).Return(nil)
 				Expect(is.Populate(ctx)).To(Succeed())
 
 				content, err := os.ReadFile(filepath.Join(dataDir, "rhcos-full-iso-4.8-48.84.202109241901-0-x86_64.iso"))
@@ -247,7 +247,7 @@ var _ = Context("with a data directory configured", func() {
 				Expect(err).NotTo(HaveOccurred())
 
 				rootfs := fmt.Sprintf(rootfsURL, version["openshift_version"])
-				mockEditor.EXPECT().CreateMinimalISOTemplate(gomock.Any(), rootfs, "x86_64", gomock.Any()).Return(nil)
+				mockEditor.EXPECT().CreateMinimalISOTemplate(gomock.Any(), gomock.Any(), rootfs, "x86_64", gomock.Any()).Return(nil)
 				Expect(is.Populate(ctx)).To(Succeed())
 
 				content, err := os.ReadFile(filepath.Join(dataDir, "rhcos-full-iso-4.8-48.84.202109241901-0-x86_64.iso"))
@@ -274,7 +274,7 @@ var _ = Context("with a data directory configured", func() {
 				Expect(err).NotTo(HaveOccurred())
 
 				rootfs := fmt.Sprintf(rootfsURL, version["openshift_version"])
-				mockEditor.EXPECT().CreateMinimalISOTemplate(gomock.Any(), rootfs, "x86_64", gomock.Any()).Return(nil)
+				mockEditor.EXPECT().CreateMinimalISOTemplate(gomock.Any(), gomock.Any(), rootfs, "x86_64", gomock.Any()).Return(nil)
 				Expect(is.Populate(ctx)).To(Succeed())
 
 				content, err := os.ReadFile(filepath.Join(dataDir, "rhcos-full-iso-4.8-48.84.202109241901-0-x86_64.iso"))
@@ -295,7 +295,7 @@ var _ = Context("with a data directory configured", func() {
 				Expect(err).NotTo(HaveOccurred())
 
 				rootfs := fmt.Sprintf(rootfsURL, version["openshift_version"])
-				mockEditor.EXPECT().CreateMinimalISOTemplate(gomock.Any(), rootfs, "x86_64", gomock.Any()).Return(nil)
+				mockEditor.EXPECT().CreateMinimalISOTemplate(gomock.Any(), gomock.Any(), rootfs, "x86_64", gomock.Any()).Return(nil)
 				Expect(is.Populate(ctx)).To(Succeed())
 
 				content, err := os.ReadFile(filepath.Join(dataDir, "rhcos-full-iso-4.8-48.84.202109241901-0-x86_64.iso"))
@@ -347,7 +347,7 @@ var _ = Context("with a data directory configured", func() {
 				Expect(err).NotTo(HaveOccurred())
 
 				rootfs := fmt.Sprintf(rootfsURL, version["openshift_version"])
-				mockEditor.EXPECT().CreateMinimalISOTemplate(gomock.Any(), rootfs, "x86_64", gomock.Any()).Return(fmt.Errorf("minimal iso creation failed"))
+				mockEditor.EXPECT().CreateMinimalISOTemplate(gomock.Any(), gomock.Any(), rootfs, "x86_64", gomock.Any()).Return(fmt.Errorf("minimal iso creation failed"))
 				Expect(is.Populate(ctx)).NotTo(Succeed())
 			})
 
@@ -365,7 +365,7 @@ var _ = Context("with a data directory configured", func() {
 				Expect(os.WriteFile(filepath.Join(dataDir, "rhcos-full-iso-4.8-48.84.202109241901-0-x86_64.iso"), []byte("moreisocontent"), 0600)).To(Succeed())
 
 				rootfs := fmt.Sprintf(rootfsURL, version["openshift_version"])
-				mockEditor.EXPECT().CreateMinimalISOTemplate(gomock.Any(), rootfs, "x86_64", gomock.Any()).Return(nil)
+				mockEditor.EXPECT().CreateMinimalISOTemplate(gomock.Any(), gomock.Any(), rootfs, "x86_64", gomock.Any()).Return(nil)
 				Expect(is.Populate(ctx)).To(Succeed())
 			})
 
@@ -380,7 +380,7 @@ var _ = Context("with a data directory configured", func() {
 				Expect(os.WriteFile(minimalPath, []byte("minimalisocontent"), 0600)).To(Succeed())
 
 				rootfs := fmt.Sprintf(rootfsURL, version["openshift_version"])
-				mockEditor.EXPECT().CreateMinimalISOTemplate(fullPath, rootfs, "x86_64", minimalPath).Return(nil)
+				mockEditor.EXPECT().CreateMinimalISOTemplate(gomock.Any(), fullPath, rootfs, "x86_64", minimalPath).Return(nil)
 
 				Expect(is.Populate(ctx)).To(Succeed())
 			})
@@ -398,7 +398,7 @@ var _ = Context("with a data directory configured", func() {
 				Expect(err).NotTo(HaveOccurred())
 
 				rootfs := fmt.Sprintf(rootfsURL, versionPatch["openshift_version"])
-				mockEditor.EXPECT().CreateMinimalISOTemplate(gomock.Any(), rootfs, "x86_64", gomock.Any()).Return(nil)
+				mockEditor.EXPECT().CreateMinimalISOTemplate(gomock.Any(), gomock.Any(), rootfs, "x86_64", gomock.Any()).Return(nil)
 				Expect(is.Populate(ctx)).To(Succeed())
 
 				content, err := os.ReadFile(filepath.Join(dataDir, "rhcos-full-iso-4.8.1-48.84.202109241901-0-x86_64.iso"))
@@ -421,7 +421,7 @@ var _ = Context("with a data directory configured", func() {
 					Expect(err).NotTo(HaveOccurred())
 
 					rootfs := fmt.Sprintf(rootfsURL, versionPatch["openshift_version"])
-					mockEditor.EXPECT().CreateMinimalISOTemplate(gomock.Any(), rootfs, "x86_64", gomock.Any()).Return(nil)
+					mockEditor.EXPECT().CreateMinimalISOTemplate(gomock.Any(), gomock.Any(), rootfs, "x86_64", gomock.Any()).Return(nil)
 					Expect(is.Populate(ctx)).To(Succeed())
 				}
 			})
@@ -442,7 +442,7 @@ var _ = Context("with a data directory configured", func() {
 				Expect(err).NotTo(HaveOccurred())
 
 				rootfs := fmt.Sprintf(rootfsURL, version["openshift_version"])
-				mockEditor.EXPECT().CreateMinimalISOTemplate(gomock.Any(), rootfs, "x86_64", gomock.Any()).Return(nil)
+				mockEditor.EXPECT().CreateMinimalISOTemplate(gomock.Any(), gomock.Any(), rootfs, "x86_64", gomock.Any()).Return(nil)
 				Expect(is.Populate(ctx)).To(Succeed())
 
 				_, err = os.Stat(oldISOPath)
@@ -472,7 +472,7 @@ var _ = Context("with a data directory configured", func() {
 				is, err := NewImageStore(mockEditor, dataDir, "", false, []map[string]string{version}, "", osImageDownloadHeadersMap, osImageDownloadQueryParamsMap)
 				Expect(err).NotTo(HaveOccurred())
 
-				mockEditor.EXPECT().CreateMinimalISOTemplate(gomock.Any(), "", "x86_64", gomock.Any()).Return(nil)
+				mockEditor.EXPECT().CreateMinimalISOTemplate(gomock.Any(), gomock.Any(), "", "x86_64", gomock.Any()).Return(nil)
 				Expect(is.Populate(ctx)).NotTo(Succeed())
 			})
 
@@ -490,7 +490,7 @@ var _ = Context("with a data directory configured", func() {
 				Expect(err).ToNot(HaveOccurred())
 
 				rootfs := fmt.Sprintf("https://images.example.com/api/assisted-images/boot-artifacts/rootfs?arch=x86_64&version=%s", version["openshift_version"])
-				mockEditor.EXPECT().CreateMinimalISOTemplate(gomock.Any(), rootfs, "x86_64", gomock.Any()).Return(nil)
+				mockEditor.EXPECT().CreateMinimalISOTemplate(gomock.Any(), gomock.Any(), rootfs, "x86_64", gomock.Any()).Return(nil)
 				err = is.Populate(ctx)
 				Expect(err).ToNot(Succeed())
 				Expect(err.Error()).To(Equal("failed to build rootfs URL: parse \":\": missing protocol scheme"))
@@ -0,0 +1,118 @@
+package isoeditor
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type ChecksumAlgorithm string
+
+const (
+	ChecksumSHA256 ChecksumAlgorithm = "sha256"
+	ChecksumSHA512 ChecksumAlgorithm = "sha512"
+
+	DefaultChecksumAlgorithm = ChecksumSHA256
+)
+
+func (a ChecksumAlgorithm) newHash() (hash.Hash, error) {
+	switch a {
+	case ChecksumSHA256:
+		return sha256.New(), nil
+	case ChecksumSHA512:
+		return sha512.New(), nil
+	}
+	return nil, fmt.Errorf("unsupported checksum algorithm %q", a)
+}
+
+// FileChecksum returns the hex encoded checksum of the file contents
+func FileChecksum(path string, algorithm ChecksumAlgorithm) (string, error) {
+	sum, _, err := fileChecksumAndSize(path, algorithm)
+	return sum, err
+}
+
+// fileChecksumAndSize returns the hex encoded checksum and the size of the file contents, read once
+func fileChecksumAndSize(path string, algorithm ChecksumAlgorithm) (string, int64, error) {
+	h, err := algorithm.newHash()
+	if err != nil {
+		return "", 0, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return "", 0, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), size, nil
+}
+
+// checksumAlgorithmOf returns the algorithm of a hex encoded checksum from its length
+func checksumAlgorithmOf(sum string) (ChecksumAlgorithm, error) {
+	switch len(sum) {
+	case sha256.Size * 2:
+		return ChecksumSHA256, nil
+	case sha512.Size * 2:
+		return ChecksumSHA512, nil
+	}
+	return "", fmt.Errorf("invalid checksum %q, expected a hex encoded SHA-256 or SHA-512 checksum", sum)
+}
+
+// VerifyISO checks the ISO contents against the hex encoded SHA-256 or SHA-512 checksum, the algorithm
+// being told from the checksum length
+func VerifyISO(path, expectedSum string) error {
+	algorithm, err := checksumAlgorithmOf(expectedSum)
+	if err != nil {
+		return err
+	}
+	sum, err := FileChecksum(path, algorithm)
+	if err != nil {
+		return err
+	}
+	if sum != strings.ToLower(expectedSum) {
+		return fmt.Errorf("%s checksum mismatch for %s: expected %s, got %s", algorithm, path, expectedSum, sum)
+	}
+	return nil
+}
+
+// ChecksumSidecarPath returns the path of the checksum file written next to the given file
+func ChecksumSidecarPath(path string, algorithm ChecksumAlgorithm) string {
+	return fmt.Sprintf("%s.%s", path, algorithm)
+}
+
+// writeChecksumSidecar writes the checksum of the file next to it in the format used by sha256sum
+func writeChecksumSidecar(path string, algorithm ChecksumAlgorithm, sum string) error {
+	content := fmt.Sprintf("%s  %s\n", sum, filepath.Base(path))
+	return os.WriteFile(ChecksumSidecarPath(path, algorithm), []byte(content), 0600)
+}
+
+// VerifyChecksumSidecar checks the file contents against the checksum file written next to it
+func VerifyChecksumSidecar(path string, algorithm ChecksumAlgorithm) error {
+	content, err := os.ReadFile(ChecksumSidecarPath(path, algorithm))
+	if err != nil {
+		return err
+	}
+	fields := strings.Fields(string(content))
+	if len(fields) != 2 || fields[1] != filepath.Base(path) {
+		return fmt.Errorf("invalid checksum file for %s", path)
+	}
+
+	sum, err := FileChecksum(path, algorithm)
+	if err != nil {
+		return err
+	}
+	if sum != fields[0] {
+		return fmt.Errorf("%s checksum mismatch for %s: expected %s, got %s", algorithm, path, fields[0], sum)
+	}
+	return nil
+}
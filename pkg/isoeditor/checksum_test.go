@@ -0,0 +1,192 @@
+package isoeditor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("checksums", func() {
+	var (
+		filesDir       string
+		isoFile        string
+		workDir        string
+		minimalISOPath string
+	)
+
+	BeforeEach(func() {
+		filesDir, isoFile = createTestFiles("Assisted123")
+
+		var err error
+		workDir, err = os.MkdirTemp("", "testchecksum")
+		Expect(err).NotTo(HaveOccurred())
+		minimalISOPath = filepath.Join(workDir, "minimal.iso")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(filesDir)).To(Succeed())
+		Expect(os.Remove(isoFile)).To(Succeed())
+		Expect(os.RemoveAll(workDir)).To(Succeed())
+	})
+
+	It("writes a SHA-512 sidecar for the minimal iso", func() {
+		editor := NewEditor(workDir, WithChecksumAlgorithm(ChecksumSHA512), WithChecksumSidecar())
+		Expect(editor.CreateMinimalISOTemplate(context.Background(), isoFile, testRootFSURL, "x86_64", minimalISOPath)).To(Succeed())
+
+		sidecarPath := ChecksumSidecarPath(minimalISOPath, ChecksumSHA512)
+		Expect(sidecarPath).To(Equal(minimalISOPath + ".sha512"))
+		content, err := os.ReadFile(sidecarPath)
+		Expect(err).ToNot(HaveOccurred())
+		sum, err := FileChecksum(minimalISOPath, ChecksumSHA512)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(sum).To(HaveLen(128))
+		Expect(string(content)).To(Equal(sum + "  minimal.iso\n"))
+
+		Expect(VerifyChecksumSidecar(minimalISOPath, ChecksumSHA512)).To(Succeed())
+	})
+
+	It("does not write a sidecar by default", func() {
+		editor := NewEditor(workDir)
+		Expect(editor.CreateMinimalISOTemplate(context.Background(), isoFile, testRootFSURL, "x86_64", minimalISOPath)).To(Succeed())
+
+		exists, err := fileExists(ChecksumSidecarPath(minimalISOPath, DefaultChecksumAlgorithm))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(exists).To(BeFalse())
+	})
+
+	It("detects a modified iso", func() {
+		editor := NewEditor(workDir, WithChecksumAlgorithm(ChecksumSHA512), WithChecksumSidecar())
+		Expect(editor.CreateMinimalISOTemplate(context.Background(), isoFile, testRootFSURL, "x86_64", minimalISOPath)).To(Succeed())
+
+		f, err := os.OpenFile(minimalISOPath, os.O_WRONLY|os.O_APPEND, 0600)
+		Expect(err).ToNot(HaveOccurred())
+		_, err = f.Write([]byte("modified"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(f.Close()).To(Succeed())
+
+		err = VerifyChecksumSidecar(minimalISOPath, ChecksumSHA512)
+		Expect(err).To(MatchError(ContainSubstring("checksum mismatch")))
+	})
+
+	It("reports the checksum and size of the minimal iso", func() {
+		events := make(chan BuildEvent, 100)
+		editor := NewEditor(workDir, WithChecksum(), WithBuildEvents(events), WithBuildReport())
+		Expect(editor.CreateMinimalISOTemplate(context.Background(), isoFile, testRootFSURL, "x86_64", minimalISOPath)).To(Succeed())
+		close(events)
+
+		var completed BuildEvent
+		for event := range events {
+			completed = event
+		}
+		Expect(completed.Type).To(Equal(BuildEventCompleted))
+		sum, err := FileChecksum(minimalISOPath, ChecksumSHA256)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(completed.Checksum).To(Equal(sum))
+		info, err := os.Stat(minimalISOPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(completed.Size).To(Equal(info.Size()))
+
+		report, err := ReadBuildReport(BuildReportPath(minimalISOPath))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(report.Checksum).To(Equal(sum))
+		Expect(report.ChecksumAlgorithm).To(Equal(ChecksumSHA256))
+
+		exists, err := fileExists(ChecksumSidecarPath(minimalISOPath, ChecksumSHA256))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(exists).To(BeFalse())
+	})
+
+	It("returns the checksum of a patched minimal iso in the build result", func() {
+		var result BuildResult
+		epoch := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+		editor := NewEditor(workDir, WithChecksum(), WithBuildResult(&result), WithSourceDateEpoch(epoch),
+			WithVolumeProvenance(VolumeProvenance{Publisher: "PIPELINE 42"}))
+		Expect(editor.CreateMinimalISOTemplate(context.Background(), isoFile, testRootFSURL, "x86_64", minimalISOPath)).To(Succeed())
+
+		provenance, err := ReadVolumeProvenance(minimalISOPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(provenance.Publisher).To(Equal("PIPELINE 42"))
+		sum, err := FileChecksum(minimalISOPath, ChecksumSHA256)
+		Expect(err).ToNot(HaveOccurred())
+		info, err := os.Stat(minimalISOPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(result.Checksum).To(Equal(sum))
+		Expect(result.ChecksumAlgorithm).To(Equal(ChecksumSHA256))
+		Expect(result.Size).To(Equal(info.Size()))
+	})
+
+	Describe("isoOutput", func() {
+		var outPath string
+
+		// write writes the chunks through an isoOutput with the patches and returns its checksum
+		write := func(patches map[int64][]byte, chunks map[int64]string, order ...int64) string {
+			f, err := os.Create(outPath)
+			Expect(err).ToNot(HaveOccurred())
+			hash, err := ChecksumSHA256.newHash()
+			Expect(err).ToNot(HaveOccurred())
+			output := &isoOutput{File: f, ctx: context.Background(), patches: patches, algorithm: ChecksumSHA256, hash: hash}
+			for _, offset := range order {
+				_, err = output.WriteAt([]byte(chunks[offset]), offset)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			Expect(f.Close()).To(Succeed())
+
+			sum, size, err := output.checksum(outPath)
+			Expect(err).ToNot(HaveOccurred())
+			info, err := os.Stat(outPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(size).To(Equal(info.Size()))
+			return sum
+		}
+
+		BeforeEach(func() {
+			outPath = filepath.Join(workDir, "out.iso")
+		})
+
+		It("applies the patches and hashes the written bytes", func() {
+			chunks := map[int64]string{0: "system area", 100: "descriptor", 200: "files"}
+			for _, order := range [][]int64{{0, 100, 200}, {200, 0, 100}} {
+				sum := write(map[int64][]byte{104: []byte("XX"), 198: []byte("PATCH")}, chunks, order...)
+
+				content, err := os.ReadFile(outPath)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(string(content[100:110])).To(Equal("descXXptor"))
+				Expect(string(content[200:205])).To(Equal("TCHes"))
+				Expect(content[50]).To(Equal(byte(0)))
+				expected, err := FileChecksum(outPath, ChecksumSHA256)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(sum).To(Equal(expected))
+			}
+		})
+	})
+
+	Describe("VerifyISO", func() {
+		It("verifies SHA-256 and SHA-512 checksums", func() {
+			for _, algorithm := range []ChecksumAlgorithm{ChecksumSHA256, ChecksumSHA512} {
+				sum, err := FileChecksum(isoFile, algorithm)
+				Expect(err).ToNot(HaveOccurred())
+				Expect(VerifyISO(isoFile, sum)).To(Succeed())
+			}
+		})
+
+		It("detects a mismatch", func() {
+			sum, err := FileChecksum(filepath.Join(filesDir, "EFI/redhat/grub.cfg"), ChecksumSHA256)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(VerifyISO(isoFile, sum)).To(MatchError(ContainSubstring("sha256 checksum mismatch")))
+		})
+
+		It("rejects a checksum of an unknown length", func() {
+			Expect(VerifyISO(isoFile, "abcd")).To(MatchError(ContainSubstring("invalid checksum")))
+		})
+	})
+
+	It("fails with an unsupported algorithm", func() {
+		editor := NewEditor(workDir, WithChecksumAlgorithm("md5"), WithChecksumSidecar())
+		err := editor.CreateMinimalISOTemplate(context.Background(), isoFile, testRootFSURL, "x86_64", minimalISOPath)
+		Expect(err).To(MatchError(ContainSubstring("unsupported checksum algorithm")))
+	})
+})
@@ -0,0 +1,131 @@
+package isoeditor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/cavaliercoder/go-cpio"
+	"github.com/pkg/errors"
+)
+
+// GenerateCompressedCPIO returns a gzip compressed CPIO archive holding a single regular file with the
+// content at path, e.g. to build a supplemental ramdisk appended to an initrd. The path is relative to
+// the root of the ramdisk, a leading slash is ignored, and only the permission bits of the mode are kept.
+// The archive holds neither timestamps nor ownership, so the same arguments always give the same bytes.
+func GenerateCompressedCPIO(content []byte, path string, mode os.FileMode) ([]byte, error) {
+	b := NewCPIOBuilder()
+	if err := b.AddFile(path, content, mode); err != nil {
+		return nil, err
+	}
+	return b.Archive(ArchiveCompressionGzip)
+}
+
+// CPIOBuilder accumulates the entries of a CPIO archive, which are written in the order they were added.
+// Directories aren't created implicitly, they must be added before the entries they hold.
+type CPIOBuilder struct {
+	entries []cpioEntry
+	names   map[string]bool
+}
+
+type cpioEntry struct {
+	header  cpio.Header
+	content []byte
+}
+
+// NewCPIOBuilder returns a builder of an empty archive
+func NewCPIOBuilder() *CPIOBuilder {
+	return &CPIOBuilder{names: map[string]bool{}}
+}
+
+// AddFile adds a regular file with the content and the permission bits of the mode
+func (b *CPIOBuilder) AddFile(path string, content []byte, mode os.FileMode) error {
+	return b.add(path, cpio.ModeRegular|cpio.FileMode(mode.Perm()), content)
+}
+
+// AddDir adds a directory with the permission bits of the mode
+func (b *CPIOBuilder) AddDir(path string, mode os.FileMode) error {
+	return b.add(path, cpio.ModeDir|cpio.FileMode(mode.Perm()), nil)
+}
+
+// AddSymlink adds a symbolic link to the target, which is kept as is
+func (b *CPIOBuilder) AddSymlink(path, target string) error {
+	if target == "" {
+		return fmt.Errorf("empty target for symlink %s", path)
+	}
+	return b.add(path, cpio.ModeSymlink|cpio.ModePerm, []byte(target))
+}
+
+func (b *CPIOBuilder) add(name string, mode cpio.FileMode, content []byte) error {
+	cleaned := strings.TrimPrefix(path.Clean("/"+name), "/")
+	if cleaned == "" || name == "" {
+		return fmt.Errorf("invalid CPIO entry path %q", name)
+	}
+	if b.names[cleaned] {
+		return fmt.Errorf("duplicate CPIO entry %s", cleaned)
+	}
+	b.names[cleaned] = true
+	b.entries = append(b.entries, cpioEntry{
+		header:  cpio.Header{Name: cleaned, Mode: mode, Size: int64(len(content))},
+		content: content,
+	})
+	return nil
+}
+
+// Archive returns the archive of the entries added so far with the given compression
+func (b *CPIOBuilder) Archive(compression ArchiveCompression) ([]byte, error) {
+	archiveBuffer, err := b.archive(compression)
+	if err != nil {
+		return nil, err
+	}
+	return archiveBuffer.Bytes(), nil
+}
+
+func (b *CPIOBuilder) archive(compression ArchiveCompression) (*bytes.Buffer, error) {
+	archiveBuffer := new(bytes.Buffer)
+	var compressor io.WriteCloser
+	switch compression {
+	case ArchiveCompressionGzip:
+		// Run gzip compression, with a pinned level and the default header holding no name nor time
+		// so that the same entries always give the same archive
+		var err error
+		if compressor, err = gzip.NewWriterLevel(archiveBuffer, gzip.DefaultCompression); err != nil {
+			return nil, err
+		}
+	case ArchiveCompressionNone:
+		compressor = nopWriteCloser{archiveBuffer}
+	default:
+		return nil, fmt.Errorf("unsupported archive compression %q", compression)
+	}
+	// Create CPIO archive
+	cpioWriter := cpio.NewWriter(compressor)
+
+	for i := range b.entries {
+		entry := &b.entries[i]
+		header := entry.header
+		if err := cpioWriter.WriteHeader(&header); err != nil {
+			return nil, errors.Wrap(err, "Failed to write CPIO header")
+		}
+		if _, err := cpioWriter.Write(entry.content); err != nil {
+			return nil, errors.Wrap(err, "Failed to write CPIO archive")
+		}
+	}
+
+	if err := cpioWriter.Close(); err != nil {
+		return nil, errors.Wrap(err, "Failed to close CPIO archive")
+	}
+	if err := compressor.Close(); err != nil {
+		return nil, errors.Wrapf(err, "Failed to %s CPIO archive", compression)
+	}
+	return archiveBuffer, nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
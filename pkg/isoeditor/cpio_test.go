@@ -0,0 +1,106 @@
+package isoeditor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+
+	"github.com/cavaliercoder/go-cpio"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("CPIO archives", func() {
+	type entry struct {
+		mode     cpio.FileMode
+		content  string
+		linkname string
+	}
+
+	// readArchive returns the entries of the archive by name, in the order they were read
+	readArchive := func(archive []byte, compression ArchiveCompression) ([]string, map[string]entry) {
+		var r io.Reader = bytes.NewReader(archive)
+		if compression == ArchiveCompressionGzip {
+			gz, err := gzip.NewReader(r)
+			Expect(err).NotTo(HaveOccurred())
+			r = gz
+		}
+
+		var names []string
+		entries := map[string]entry{}
+		cpioReader := cpio.NewReader(r)
+		for {
+			hdr, err := cpioReader.Next()
+			if err == io.EOF {
+				return names, entries
+			}
+			Expect(err).NotTo(HaveOccurred())
+			content, err := io.ReadAll(cpioReader)
+			Expect(err).NotTo(HaveOccurred())
+			names = append(names, hdr.Name)
+			entries[hdr.Name] = entry{mode: hdr.Mode, content: string(content), linkname: hdr.Linkname}
+		}
+	}
+
+	Describe("GenerateCompressedCPIO", func() {
+		It("archives a single file", func() {
+			archive, err := GenerateCompressedCPIO([]byte("network config"), "/etc/network/config.yml", 0o600)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(archive[:2]).To(Equal(gzipMagic))
+
+			names, entries := readArchive(archive, ArchiveCompressionGzip)
+			Expect(names).To(Equal([]string{"etc/network/config.yml"}))
+			Expect(entries["etc/network/config.yml"]).To(Equal(entry{mode: cpio.ModeRegular | 0o600, content: "network config"}))
+		})
+
+		It("is reproducible", func() {
+			first, err := GenerateCompressedCPIO([]byte("content"), "file", 0o644)
+			Expect(err).NotTo(HaveOccurred())
+			second, err := GenerateCompressedCPIO([]byte("content"), "file", 0o644)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(first).To(Equal(second))
+		})
+
+		It("fails with an empty path", func() {
+			_, err := GenerateCompressedCPIO([]byte("content"), "/", 0o644)
+			Expect(err).To(MatchError(ContainSubstring("invalid CPIO entry path")))
+		})
+	})
+
+	Describe("CPIOBuilder", func() {
+		It("archives files, directories and symlinks in order", func() {
+			b := NewCPIOBuilder()
+			Expect(b.AddDir("usr", 0o755)).To(Succeed())
+			Expect(b.AddDir("usr/bin", 0o755)).To(Succeed())
+			Expect(b.AddFile("usr/bin/tool", []byte("#!/bin/sh\n"), 0o755)).To(Succeed())
+			Expect(b.AddSymlink("usr/bin/alias", "tool")).To(Succeed())
+
+			for _, compression := range []ArchiveCompression{ArchiveCompressionGzip, ArchiveCompressionNone} {
+				archive, err := b.Archive(compression)
+				Expect(err).NotTo(HaveOccurred())
+
+				names, entries := readArchive(archive, compression)
+				Expect(names).To(Equal([]string{"usr", "usr/bin", "usr/bin/tool", "usr/bin/alias"}))
+				Expect(entries["usr"].mode).To(Equal(cpio.FileMode(cpio.ModeDir | 0o755)))
+				Expect(entries["usr/bin/tool"]).To(Equal(entry{mode: cpio.ModeRegular | 0o755, content: "#!/bin/sh\n"}))
+				Expect(entries["usr/bin/alias"].mode).To(Equal(cpio.FileMode(cpio.ModeSymlink | cpio.ModePerm)))
+				Expect(entries["usr/bin/alias"].linkname).To(Equal("tool"))
+			}
+		})
+
+		It("rejects duplicate entries", func() {
+			b := NewCPIOBuilder()
+			Expect(b.AddFile("etc/file", []byte("a"), 0o644)).To(Succeed())
+			Expect(b.AddDir("/etc/file", 0o755)).To(MatchError(ContainSubstring("duplicate CPIO entry etc/file")))
+		})
+
+		It("rejects a symlink without target", func() {
+			Expect(NewCPIOBuilder().AddSymlink("link", "")).To(MatchError(ContainSubstring("empty target")))
+		})
+
+		It("fails with an unsupported compression", func() {
+			_, err := NewCPIOBuilder().Archive("zstd")
+			Expect(err).To(MatchError(ContainSubstring("unsupported archive compression")))
+		})
+	})
+})
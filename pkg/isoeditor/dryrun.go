@@ -0,0 +1,84 @@
+package isoeditor
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DryRunReport describes the edits of a minimal ISO build that stopped before creating the ISO, see WithDryRun
+type DryRunReport struct {
+	VolumeID string `json:"volumeID"`
+	Arch     string `json:"arch"`
+	// InitrdImages are the images added to the initrd images of the boot configs
+	InitrdImages []string `json:"initrdImages"`
+	// EditedFiles are the sorted paths within the ISO of the edited boot configs
+	EditedFiles []string `json:"editedFiles"`
+	// Edits are the changed lines of the edited boot configs by path within the ISO
+	Edits    map[string]BootConfigEdit `json:"edits"`
+	Warnings []BuildWarning            `json:"warnings,omitempty"`
+}
+
+// BootConfigEdit holds the lines of a boot config removed and added by a build
+type BootConfigEdit struct {
+	Removed []string `json:"removed,omitempty"`
+	Added   []string `json:"added,omitempty"`
+}
+
+// WithDryRun makes the build fill the report once the boot configs are edited instead of creating the
+// minimal ISO. The rootfs and the placeholders are left untouched, but the boot configs of the extract
+// dir are edited. It can't be used with CreateMinimalISOs.
+func WithDryRun(report *DryRunReport) MinimalISOOption {
+	return func(o *minimalISOOptions) {
+		o.dryRun = report
+	}
+}
+
+// setEdits sets the edits of the report from the boot configs read before and after the build
+func (r *DryRunReport) setEdits(extractDir string, before, after map[string][]byte) error {
+	r.Edits = map[string]BootConfigEdit{}
+	r.EditedFiles = nil
+	for path, content := range after {
+		edit := diffLines(string(before[path]), string(content))
+		if len(edit.Removed) == 0 && len(edit.Added) == 0 {
+			continue
+		}
+		rel, err := filepath.Rel(extractDir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		r.Edits[rel] = edit
+		r.EditedFiles = append(r.EditedFiles, rel)
+	}
+	sort.Strings(r.EditedFiles)
+	return nil
+}
+
+// diffLines returns the lines of before missing from after and the lines of after missing from before,
+// in order, without trying to match moved lines
+func diffLines(before, after string) BootConfigEdit {
+	beforeLines := strings.Split(before, "\n")
+	afterLines := strings.Split(after, "\n")
+	return BootConfigEdit{
+		Removed: missingLines(beforeLines, afterLines),
+		Added:   missingLines(afterLines, beforeLines),
+	}
+}
+
+// missingLines returns the lines that aren't in other, each line of other matching a single line
+func missingLines(lines, other []string) []string {
+	counts := map[string]int{}
+	for _, line := range other {
+		counts[line]++
+	}
+	var missing []string
+	for _, line := range lines {
+		if counts[line] > 0 {
+			counts[line]--
+		} else {
+			missing = append(missing, line)
+		}
+	}
+	return missing
+}
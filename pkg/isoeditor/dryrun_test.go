@@ -0,0 +1,70 @@
+package isoeditor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("dry run", func() {
+	var (
+		filesDir       string
+		isoFile        string
+		workDir        string
+		minimalISOPath string
+	)
+
+	BeforeEach(func() {
+		filesDir, isoFile = createTestFiles("Assisted123")
+
+		var err error
+		workDir, err = os.MkdirTemp("", "testdryrun")
+		Expect(err).NotTo(HaveOccurred())
+		minimalISOPath = filepath.Join(workDir, "minimal.iso")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(filesDir)).To(Succeed())
+		Expect(os.Remove(isoFile)).To(Succeed())
+		Expect(os.RemoveAll(workDir)).To(Succeed())
+	})
+
+	It("reports the edits of a template build without creating it", func() {
+		var report DryRunReport
+		editor := NewEditor(workDir, WithDryRun(&report))
+		Expect(editor.CreateMinimalISOTemplate(context.Background(), isoFile, testRootFSURL, "x86_64", minimalISOPath)).To(Succeed())
+
+		Expect(minimalISOPath).ToNot(BeAnExistingFile())
+		entries, err := os.ReadDir(workDir)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(entries).To(BeEmpty())
+
+		Expect(report.VolumeID).To(Equal("Assisted123"))
+		Expect(report.Arch).To(Equal("x86_64"))
+		Expect(report.InitrdImages).To(Equal([]string{ramDiskImagePath}))
+		Expect(report.EditedFiles).To(Equal([]string{"EFI/redhat/grub.cfg", "isolinux/isolinux.cfg"}))
+		Expect(report.Edits["EFI/redhat/grub.cfg"]).To(Equal(BootConfigEdit{
+			Removed: []string{
+				"	linux /images/pxeboot/vmlinuz random.trust_cpu=on rd.luks.options=discard coreos.liveiso=rhcos-46.82.202010091720-0 ignition.firstboot ignition.platform.id=metal",
+				"	initrd /images/pxeboot/initrd.img /images/ignition.img",
+			},
+			Added: []string{
+				fmt.Sprintf("	linux /images/pxeboot/vmlinuz random.trust_cpu=on rd.luks.options=discard ignition.firstboot ignition.platform.id=metal 'coreos.live.rootfs_url=%s'", testRootFSURL),
+				"	initrd /images/pxeboot/initrd.img /images/ignition.img " + ramDiskImagePath,
+			},
+		}))
+	})
+
+	It("leaves the rootfs of the extract dir", func() {
+		var report DryRunReport
+		Expect(CreateMinimalISO(filesDir, "Assisted123", testRootFSURL, "x86_64", minimalISOPath, WithDryRun(&report))).To(Succeed())
+
+		Expect(minimalISOPath).ToNot(BeAnExistingFile())
+		Expect(filepath.Join(filesDir, rootFSPathInISO)).To(BeAnExistingFile())
+		Expect(report.EditedFiles).To(ContainElement("isolinux/isolinux.cfg"))
+	})
+})
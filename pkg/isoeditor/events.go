@@ -0,0 +1,157 @@
+package isoeditor
+
+import (
+	"fmt"
+	"time"
+)
+
+type BuildEventType string
+
+const (
+	BuildEventStageStarted  BuildEventType = "StageStarted"
+	BuildEventStageFinished BuildEventType = "StageFinished"
+	BuildEventWarning       BuildEventType = "Warning"
+	BuildEventCompleted     BuildEventType = "Completed"
+)
+
+// Stages of a minimal ISO build
+const (
+	StageExtract           = "extract"
+	StageVolumeIdentifier  = "volume-identifier"
+	StageDetectArch        = "detect-arch"
+	StageRemoveRootFS      = "remove-rootfs"
+	StageEmbedPlaceholders = "embed-placeholders"
+	StageGrubConfig        = "grub-config"
+	StageIsolinuxConfig    = "isolinux-config"
+	StagePrmConfig         = "prm-config"
+	StageCreate            = "create"
+	StageChecksum          = "checksum"
+	StageReport            = "report"
+	StagePostProcess       = "post-process"
+)
+
+// BuildEvent describes a step of a minimal ISO build
+type BuildEvent struct {
+	Type BuildEventType
+	// Stage is set for stage and warning events
+	Stage string
+	// Message is set for warning events
+	Message string
+	// Err is set for stage finished events when the stage failed
+	Err error
+	// Path is set for the completed event to the path of the created ISO
+	Path string
+	// GrubConfig is set for the completed event to the path of the edited grub config within the ISO
+	GrubConfig string
+	// Isolinux is set for the completed event when the isolinux config was edited
+	Isolinux bool
+	// Warning is set for warning events raised while editing the boot configs
+	Warning *BuildWarning
+	// Warnings is set for the completed event to all the warnings raised while editing the boot configs
+	Warnings []BuildWarning
+	// Checksum and Size are set for the completed event to those of the created ISO with WithChecksum
+	// or WithChecksumSidecar
+	Checksum string
+	Size     int64
+}
+
+type BuildWarningKind string
+
+const (
+	// BuildWarningMissingConfig is raised for a boot config expected for the arch that is missing
+	BuildWarningMissingConfig BuildWarningKind = "MissingConfig"
+	// BuildWarningKernelArgKept is raised for a kernel argument the minimal ISO needs that is set to another value
+	BuildWarningKernelArgKept BuildWarningKind = "KernelArgKept"
+	// BuildWarningVolumeIDTruncated is raised for a volume identifier longer than MaxVolumeIDLength
+	BuildWarningVolumeIDTruncated BuildWarningKind = "VolumeIDTruncated"
+)
+
+// BuildWarning describes a problem found while editing the boot configs that didn't stop the build
+type BuildWarning struct {
+	Kind BuildWarningKind `json:"kind"`
+	// Path is the path of the boot config within the ISO
+	Path    string `json:"path"`
+	Message string `json:"message"`
+}
+
+func (o *minimalISOOptions) emit(event BuildEvent) {
+	if o.events == nil {
+		return
+	}
+	select {
+	case o.events <- event:
+	default:
+	}
+}
+
+// ProgressFunc is called with the fraction of the whole build done as each stage starts and completes,
+// with the error of a stage that failed, and with an empty stage and 1 once the build completed
+type ProgressFunc func(stage string, fraction float64, err error)
+
+// stageWeights are the shares of the build progress of the stages, extracting and creating the ISO take
+// most of the build time. The stages that don't run add their share when the build completes.
+var stageWeights = map[string]float64{
+	StageExtract:           40,
+	StageVolumeIdentifier:  1,
+	StageDetectArch:        1,
+	StageRemoveRootFS:      1,
+	StageEmbedPlaceholders: 2,
+	StageGrubConfig:        1,
+	StageIsolinuxConfig:    1,
+	StagePrmConfig:         1,
+	StageCreate:            40,
+	StageChecksum:          8,
+	StageReport:            1,
+	StagePostProcess:       3,
+}
+
+func totalStageWeight() float64 {
+	total := 0.0
+	for _, weight := range stageWeights {
+		total += weight
+	}
+	return total
+}
+
+func (o *minimalISOOptions) reportProgress(stage string, err error) {
+	if o.progress == nil {
+		return
+	}
+	fraction := o.progressDone / totalStageWeight()
+	if stage == "" || fraction > 1 {
+		fraction = 1
+	}
+	o.progress(stage, fraction, err)
+}
+
+func (o *minimalISOOptions) warn(stage, message string) {
+	o.emit(BuildEvent{Type: BuildEventWarning, Stage: stage, Message: message})
+}
+
+// warnAll sends a warning event for each warning and returns them
+func (o *minimalISOOptions) warnAll(stage string, warnings []BuildWarning) []BuildWarning {
+	for i := range warnings {
+		o.emit(BuildEvent{Type: BuildEventWarning, Stage: stage, Message: warnings[i].Message, Warning: &warnings[i]})
+	}
+	return warnings
+}
+
+// runStage runs fn surrounded by the stage started and finished events, unless the build context is done
+func (o *minimalISOOptions) runStage(stage string, fn func() error) error {
+	if err := o.ctx.Err(); err != nil {
+		return fmt.Errorf("minimal ISO build stopped before stage %s: %w", stage, err)
+	}
+	o.emit(BuildEvent{Type: BuildEventStageStarted, Stage: stage})
+	o.reportProgress(stage, nil)
+	start := time.Now()
+	err := fn()
+	duration := time.Since(start)
+	o.recordStage(stage, duration)
+	o.recordStageMetrics(stage, duration, err)
+	o.emit(BuildEvent{Type: BuildEventStageFinished, Stage: stage, Err: err})
+	if err == nil {
+		o.progressDone += stageWeights[stage]
+	}
+	o.reportProgress(stage, err)
+	return err
+}
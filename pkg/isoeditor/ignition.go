@@ -2,47 +2,58 @@ package isoeditor
 
 import (
 	"bytes"
-	"compress/gzip"
-
-	"github.com/cavaliercoder/go-cpio"
-	"github.com/pkg/errors"
+	"sort"
 )
 
 type IgnitionContent struct {
 	Config []byte
 }
 
+// ArchiveCompression is the compression of a CPIO archive appended to an initrd
+type ArchiveCompression string
+
+const (
+	ArchiveCompressionGzip ArchiveCompression = "gzip"
+	ArchiveCompressionNone ArchiveCompression = "none"
+)
+
+// Archive returns the ignition config as a gzip compressed CPIO archive
 func (ic *IgnitionContent) Archive() (*bytes.Reader, error) {
-	// Run gzip compression
-	compressedBuffer := new(bytes.Buffer)
-	gzipWriter := gzip.NewWriter(compressedBuffer)
-	// Create CPIO archive
-	cpioWriter := cpio.NewWriter(gzipWriter)
-
-	if err := cpioWriter.WriteHeader(&cpio.Header{
-		Name: "config.ign",
-		Mode: 0o100_644,
-		Size: int64(len(ic.Config)),
-	}); err != nil {
-		return nil, errors.Wrap(err, "Failed to write CPIO header")
-	}
-	if _, err := cpioWriter.Write(ic.Config); err != nil {
-		return nil, errors.Wrap(err, "Failed to write CPIO archive")
-	}
+	return ic.ArchiveWithCompression(ArchiveCompressionGzip)
+}
 
-	if err := cpioWriter.Close(); err != nil {
-		return nil, errors.Wrap(err, "Failed to close CPIO archive")
-	}
-	if err := gzipWriter.Close(); err != nil {
-		return nil, errors.Wrap(err, "Failed to gzip ignition config")
+// ArchiveWithCompression returns the ignition config as a CPIO archive with the given compression
+func (ic *IgnitionContent) ArchiveWithCompression(compression ArchiveCompression) (*bytes.Reader, error) {
+	archiveBuffer, err := cpioArchive(map[string][]byte{"config.ign": ic.Config}, compression)
+	if err != nil {
+		return nil, err
 	}
 
-	padSize := (4 - (compressedBuffer.Len() % 4)) % 4
+	padSize := (4 - (archiveBuffer.Len() % 4)) % 4
 	for i := 0; i < padSize; i++ {
-		if err := compressedBuffer.WriteByte(0); err != nil {
+		if err := archiveBuffer.WriteByte(0); err != nil {
 			return nil, err
 		}
 	}
 
-	return bytes.NewReader(compressedBuffer.Bytes()), nil
+	return bytes.NewReader(archiveBuffer.Bytes()), nil
+}
+
+// cpioArchive returns the files as a CPIO archive with the given compression. The archive is
+// reproducible: files are written in name order and neither the CPIO nor the gzip headers hold
+// timestamps or ownership, so identical files always give identical bytes.
+func cpioArchive(files map[string][]byte, compression ArchiveCompression) (*bytes.Buffer, error) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	b := NewCPIOBuilder()
+	for _, name := range names {
+		if err := b.AddFile(name, files[name], 0o644); err != nil {
+			return nil, err
+		}
+	}
+	return b.archive(compression)
 }
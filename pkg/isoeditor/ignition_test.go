@@ -31,4 +31,24 @@ var _ = Describe("IgnitionContent.Archive", func() {
 		Expect(ignitionBytes).To(Equal(ignitionArchiveBytes))
 		Expect(len(ignitionBytes) % 4).To(Equal(0))
 	})
+
+	It("creates an uncompressed CPIO archive", func() {
+		content := IgnitionContent{ignitionContent}
+
+		data, err := content.ArchiveWithCompression(ArchiveCompressionNone)
+		Expect(err).NotTo(HaveOccurred())
+
+		ignitionBytes, err := io.ReadAll(data)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(ignitionBytes)).To(HavePrefix("070701"))
+		Expect(string(ignitionBytes)).To(ContainSubstring("someignitioncontent"))
+		Expect(len(ignitionBytes) % 4).To(Equal(0))
+	})
+
+	It("fails with an unsupported compression", func() {
+		content := IgnitionContent{ignitionContent}
+
+		_, err := content.ArchiveWithCompression("zstd")
+		Expect(err).To(MatchError(ContainSubstring("unsupported archive compression")))
+	})
 })
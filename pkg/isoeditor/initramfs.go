@@ -1,6 +1,7 @@
 package isoeditor
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"os"
@@ -38,3 +39,37 @@ func newInitRamFSStreamReaderFromStream(irfsReader io.ReadSeekCloser, ignitionCo
 	}
 	return r, nil
 }
+
+// AppendInitrdMembers appends the members, CPIO archives each using its own compression, to the initrd.
+// Every member is preceded by the padding that puts it on a 4 byte boundary, where the kernel expects it,
+// as the base initrd or the previous member may end unaligned.
+func AppendInitrdMembers(initrd overlay.OverlayReader, members ...io.ReadSeeker) (overlay.OverlayReader, error) {
+	length, err := initrd.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = initrd.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	for _, member := range members {
+		if padSize := (4 - length%4) % 4; padSize != 0 {
+			initrd, err = overlay.NewAppendReader(initrd, bytes.NewReader(make([]byte, padSize)))
+			if err != nil {
+				return nil, fmt.Errorf("failed to pad initrd member: %w", err)
+			}
+			length += padSize
+		}
+
+		memberLength, err := member.Seek(0, io.SeekEnd)
+		if err != nil {
+			return nil, err
+		}
+		initrd, err = overlay.NewAppendReader(initrd, member)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create append reader for initrd member: %w", err)
+		}
+		length += memberLength
+	}
+	return initrd, nil
+}
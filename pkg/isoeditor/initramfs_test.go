@@ -1,11 +1,14 @@
 package isoeditor
 
 import (
+	"bytes"
+	"compress/gzip"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
 
+	"github.com/cavaliercoder/go-cpio"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -46,3 +49,117 @@ var _ = Describe("NewInitRamFSStreamReader", func() {
 		Expect(output.String()).To(Equal(expected.String()))
 	})
 })
+
+var _ = Describe("AppendInitrdMembers", func() {
+	var workDir string
+
+	BeforeEach(func() {
+		var err error
+		workDir, err = os.MkdirTemp("", "testinitrd")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(workDir)).To(Succeed())
+	})
+
+	cpioArchive := func(name, content string) []byte {
+		buf := new(bytes.Buffer)
+		w := cpio.NewWriter(buf)
+		Expect(w.WriteHeader(&cpio.Header{Name: name, Mode: 0o100_644, Size: int64(len(content))})).To(Succeed())
+		_, err := w.Write([]byte(content))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(w.Close()).To(Succeed())
+		return buf.Bytes()
+	}
+
+	gzipArchive := func(name, content string) []byte {
+		buf := new(bytes.Buffer)
+		w := gzip.NewWriter(buf)
+		_, err := w.Write(cpioArchive(name, content))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(w.Close()).To(Succeed())
+		return buf.Bytes()
+	}
+
+	It("appends members with mixed compression that all unpack", func() {
+		initrdPath := filepath.Join(workDir, "initrd.img")
+		Expect(os.WriteFile(initrdPath, gzipArchive("init", "base"), 0600)).To(Succeed())
+
+		initrd, err := NewInitRamFSStreamReader(initrdPath, &IgnitionContent{[]byte("someignitioncontent")})
+		Expect(err).NotTo(HaveOccurred())
+		defer initrd.Close()
+
+		initrd, err = AppendInitrdMembers(initrd,
+			bytes.NewReader(gzipArchive("etc/gzipped", "gzipped content")),
+			bytes.NewReader(cpioArchive("etc/uncompressed", "uncompressed content")),
+		)
+		Expect(err).NotTo(HaveOccurred())
+
+		content, err := io.ReadAll(initrd)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(unpackInitrd(content)).To(Equal(map[string]string{
+			"init":             "base",
+			"config.ign":       "someignitioncontent",
+			"etc/gzipped":      "gzipped content",
+			"etc/uncompressed": "uncompressed content",
+		}))
+	})
+
+	It("pads before each member to align it", func() {
+		initrdPath := filepath.Join(workDir, "initrd.img")
+		Expect(os.WriteFile(initrdPath, []byte("initrd"), 0600)).To(Succeed())
+		initrdFile, err := os.Open(initrdPath)
+		Expect(err).NotTo(HaveOccurred())
+		defer initrdFile.Close()
+
+		initrd, err := AppendInitrdMembers(initrdFile, bytes.NewReader([]byte("odd")), bytes.NewReader([]byte("member")))
+		Expect(err).NotTo(HaveOccurred())
+		content, err := io.ReadAll(initrd)
+		Expect(err).NotTo(HaveOccurred())
+		Expect(content).To(Equal([]byte("initrd\x00\x00odd\x00member")))
+	})
+})
+
+// unpackInitrd returns the files of every member of the initrd, checking each member is 4 byte aligned
+func unpackInitrd(initrd []byte) map[string]string {
+	files := map[string]string{}
+	r := bytes.NewReader(initrd)
+	for {
+		b, err := r.ReadByte()
+		if err == io.EOF {
+			return files
+		}
+		Expect(err).NotTo(HaveOccurred())
+		if b == 0 {
+			continue
+		}
+		Expect(r.UnreadByte()).To(Succeed())
+		Expect((r.Size() - int64(r.Len())) % 4).To(BeZero())
+
+		var member io.Reader = r
+		var gz *gzip.Reader
+		if b == gzipMagic[0] {
+			gz, err = gzip.NewReader(r)
+			Expect(err).NotTo(HaveOccurred())
+			gz.Multistream(false)
+			member = gz
+		}
+
+		cpioReader := cpio.NewReader(member)
+		for {
+			hdr, err := cpioReader.Next()
+			if err == io.EOF {
+				break
+			}
+			Expect(err).NotTo(HaveOccurred())
+			content, err := io.ReadAll(cpioReader)
+			Expect(err).NotTo(HaveOccurred())
+			files[hdr.Name] = string(content)
+		}
+		if gz != nil {
+			_, err = io.Copy(io.Discard, gz)
+			Expect(err).NotTo(HaveOccurred())
+		}
+	}
+}
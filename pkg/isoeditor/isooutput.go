@@ -0,0 +1,117 @@
+package isoeditor
+
+import (
+	"context"
+	"encoding/hex"
+	"hash"
+	"os"
+
+	"github.com/diskfs/go-diskfs/util"
+)
+
+// isoOutput wraps the file diskfs writes an ISO to. It applies the patches to the bytes written at
+// their offsets, e.g. the volume dates, so the ISO isn't edited once created, and computes the
+// checksum of the ISO while it is written.
+type isoOutput struct {
+	util.File
+	// ctx fails the writes once it is done
+	ctx context.Context
+	// patches are the bytes replacing the ones written, by their offset in the ISO
+	patches   map[int64][]byte
+	algorithm ChecksumAlgorithm
+	// hash is nil when the checksum isn't needed
+	hash hash.Hash
+	// hashed is the length of the beginning of the ISO hashed so far
+	hashed int64
+	// outOfOrder is set once bytes were written before the hashed length, the ISO is then read
+	// again to compute its checksum
+	outOfOrder bool
+}
+
+func (o *isoOutput) WriteAt(p []byte, off int64) (int, error) {
+	if err := o.ctx.Err(); err != nil {
+		return 0, err
+	}
+	p = o.patch(p, off)
+	n, err := o.File.WriteAt(p, off)
+	if o.hash != nil && !o.outOfOrder {
+		o.hashWritten(p[:n], off)
+	}
+	return n, err
+}
+
+// patch returns a copy of the bytes written at off with the patches they overlap applied, or the
+// bytes themselves when they don't overlap any
+func (o *isoOutput) patch(p []byte, off int64) []byte {
+	patched := p
+	for offset, patch := range o.patches {
+		start, end := offset, offset+int64(len(patch))
+		if start < off {
+			start = off
+		}
+		if end > off+int64(len(p)) {
+			end = off + int64(len(p))
+		}
+		if start >= end {
+			continue
+		}
+		if &patched[0] == &p[0] {
+			patched = append([]byte{}, p...)
+		}
+		copy(patched[start-off:end-off], patch[start-offset:end-offset])
+	}
+	return patched
+}
+
+func (o *isoOutput) hashWritten(p []byte, off int64) {
+	if off < o.hashed {
+		o.outOfOrder = true
+		return
+	}
+	// the ranges diskfs skips read as zeros, unless they are written later
+	o.hashZeros(off - o.hashed)
+	o.hash.Write(p)
+	o.hashed = off + int64(len(p))
+}
+
+func (o *isoOutput) hashZeros(n int64) {
+	zeros := make([]byte, 32*1024)
+	for n > 0 {
+		chunk := int64(len(zeros))
+		if n < chunk {
+			chunk = n
+		}
+		o.hash.Write(zeros[:chunk])
+		n -= chunk
+	}
+}
+
+// checksum returns the hex encoded checksum and the size of the ISO written, now at path. The ISO is
+// only read when it wasn't written in order.
+func (o *isoOutput) checksum(path string) (string, int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", 0, err
+	}
+	if o.outOfOrder || info.Size() < o.hashed {
+		return fileChecksumAndSize(path, o.algorithm)
+	}
+	o.hashZeros(info.Size() - o.hashed)
+	return hex.EncodeToString(o.hash.Sum(nil)), info.Size(), nil
+}
+
+// patchISO writes the patches to the ISO at their offsets
+func patchISO(isoPath string, patches map[int64][]byte) error {
+	iso, err := os.OpenFile(isoPath, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	defer iso.Close()
+
+	for offset, patch := range patches {
+		if _, err := iso.WriteAt(patch, offset); err != nil {
+			return err
+		}
+	}
+	return iso.Sync()
+}
@@ -1,49 +1,113 @@
 package isoeditor
 
 import (
+	"context"
+	"encoding/binary"
 	"fmt"
 	"io"
 	"math"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	diskfs "github.com/diskfs/go-diskfs"
 	"github.com/diskfs/go-diskfs/disk"
 	"github.com/diskfs/go-diskfs/filesystem"
 	"github.com/diskfs/go-diskfs/filesystem/iso9660"
+	"github.com/diskfs/go-diskfs/util"
 	"github.com/pkg/errors"
+	"github.com/thoas/go-funk"
 )
 
 // Extract unpacks the iso contents into the working directory
 func Extract(isoPath string, workDir string) error {
+	return ExtractExcept(isoPath, workDir)
+}
+
+// ExtractExcept unpacks the iso contents into the working directory, except the files with the given
+// paths in the iso, e.g. the rootfs image that would be removed right away when building a minimal ISO
+func ExtractExcept(isoPath string, workDir string, skipped ...string) error {
+	return extractExcept(context.Background(), isoPath, workDir, skipped)
+}
+
+// extractExcept is ExtractExcept failing on the next read of the iso once ctx is done
+func extractExcept(ctx context.Context, isoPath string, workDir string, skipped []string) error {
 	d, err := diskfs.Open(isoPath, diskfs.WithOpenMode(diskfs.ReadOnly))
 	if err != nil {
 		return err
 	}
+	defer d.File.Close()
 
-	fs, err := GetISO9660FileSystem(d)
-	if err != nil {
-		return err
+	fs, err := iso9660.Read(contextFile{File: d.File, ctx: ctx}, d.Size, 0, 0)
+	if err == nil {
+		err = extractFileSystem(fs, workDir, skipped)
+	}
+	// diskfs doesn't wrap the errors of the reads
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
 	}
+	return err
+}
 
-	files, err := fs.ReadDir("/")
+// contextFile fails the reads of the file once the context is done
+type contextFile struct {
+	util.File
+	ctx context.Context
+}
+
+func (f contextFile) ReadAt(p []byte, off int64) (int, error) {
+	if err := f.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return f.File.ReadAt(p, off)
+}
+
+// ExtractFromReader unpacks the contents of the iso read from r, of the given size, into the working
+// directory, except the files with the given paths in the iso. Only the volume descriptors, the
+// directory records and the extracted files are read, through ranged reads of r, so r can be backed by
+// remote storage, e.g. an object store range reader, without downloading the whole iso first.
+func ExtractFromReader(r io.ReaderAt, size int64, workDir string, skipped ...string) error {
+	fs, err := iso9660.Read(readerAtFile{io.NewSectionReader(r, 0, size)}, size, 0, 0)
 	if err != nil {
 		return err
 	}
-	err = copyAll(fs, "/", files, workDir)
+
+	return extractFileSystem(fs, workDir, skipped)
+}
+
+// readerAtFile adapts a read-only section of an io.ReaderAt to the file the iso filesystem is read from
+type readerAtFile struct {
+	*io.SectionReader
+}
+
+func (readerAtFile) WriteAt([]byte, int64) (int, error) {
+	return 0, errors.New("iso is read-only")
+}
+
+func extractFileSystem(fs filesystem.FileSystem, workDir string, skipped []string) error {
+	skip := map[string]bool{}
+	for _, p := range skipped {
+		skip[path.Join("/", p)] = true
+	}
+
+	files, err := fs.ReadDir("/")
 	if err != nil {
 		return err
 	}
-
-	return nil
+	return copyAll(fs, "/", files, workDir, skip)
 }
 
-// recursive function for unpacking all files and directores from the given iso filesystem starting at fsDir
-func copyAll(fs filesystem.FileSystem, fsDir string, infos []os.FileInfo, targetDir string) error {
+// recursive function for unpacking all files and directores from the given iso filesystem starting at fsDir,
+// except the skipped ones
+func copyAll(fs filesystem.FileSystem, fsDir string, infos []os.FileInfo, targetDir string, skip map[string]bool) error {
 	for _, info := range infos {
 		osName := filepath.Join(targetDir, info.Name())
 		fsName := filepath.Join(fsDir, info.Name())
+		if skip[fsName] {
+			continue
+		}
 
 		if info.IsDir() {
 			if err := os.Mkdir(osName, info.Mode().Perm()); err != nil {
@@ -54,40 +118,200 @@ func copyAll(fs filesystem.FileSystem, fsDir string, infos []os.FileInfo, target
 			if err != nil {
 				return err
 			}
-			if err := copyAll(fs, fsName, files[:], osName); err != nil {
+			if err := copyAll(fs, fsName, files[:], osName, skip); err != nil {
 				return err
 			}
 		} else {
-			fsFile, err := fs.OpenFile(fsName, os.O_RDONLY)
-			if err != nil {
-				return err
-			}
-			osFile, err := os.Create(osName)
-			if err != nil {
+			if err := copyFile(fs, fsName, osName); err != nil {
 				return err
 			}
+		}
+	}
+	return nil
+}
 
-			_, err = io.Copy(osFile, fsFile)
-			if err != nil {
-				osFile.Close()
-				return err
-			}
+// copyFile copies a single file from the given iso filesystem to osName
+func copyFile(fs filesystem.FileSystem, fsName string, osName string) error {
+	fsFile, err := fs.OpenFile(fsName, os.O_RDONLY)
+	if err != nil {
+		return err
+	}
+	defer fsFile.Close()
 
-			if err := osFile.Sync(); err != nil {
-				osFile.Close()
-				return err
-			}
+	osFile, err := os.Create(osName)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(osFile, fsFile)
+	if err != nil {
+		osFile.Close()
+		return err
+	}
+
+	if err := osFile.Sync(); err != nil {
+		osFile.Close()
+		return err
+	}
+
+	return osFile.Close()
+}
+
+// ExtractFiles copies files out of the iso, files maps the path of each file
+// in the iso to the path it should be written to
+func ExtractFiles(isoPath string, files map[string]string) error {
+	for isoFilePath, destPath := range files {
+		if !isSafeISOPath(isoFilePath) {
+			return fmt.Errorf("invalid path %q in iso", isoFilePath)
+		}
+		if destPath == "" {
+			return fmt.Errorf("no destination path for %s", isoFilePath)
+		}
+	}
+
+	d, err := diskfs.Open(isoPath, diskfs.WithOpenMode(diskfs.ReadOnly))
+	if err != nil {
+		return err
+	}
+	defer d.File.Close()
+
+	fs, err := GetISO9660FileSystem(d)
+	if err != nil {
+		return err
+	}
 
-			if err := osFile.Close(); err != nil {
+	for isoFilePath, destPath := range files {
+		if err := copyFile(fs, path.Join("/", isoFilePath), destPath); err != nil {
+			return errors.Wrapf(err, "failed to extract %s from %s", isoFilePath, isoPath)
+		}
+	}
+	return nil
+}
+
+// ExtractFile returns the content of a single file of the iso without extracting the others.
+// The error wraps os.ErrNotExist when the iso has no file at internalPath.
+func ExtractFile(isoPath, internalPath string) ([]byte, error) {
+	if !isSafeISOPath(internalPath) {
+		return nil, fmt.Errorf("invalid path %q in iso", internalPath)
+	}
+
+	d, err := diskfs.Open(isoPath, diskfs.WithOpenMode(diskfs.ReadOnly))
+	if err != nil {
+		return nil, err
+	}
+	defer d.File.Close()
+
+	fs, err := GetISO9660FileSystem(d)
+	if err != nil {
+		return nil, err
+	}
+
+	filePath := path.Join("/", internalPath)
+	if !isoHasFile(fs, filePath) {
+		return nil, fmt.Errorf("file %s not found in %s: %w", filePath, isoPath, os.ErrNotExist)
+	}
+	f, err := fs.OpenFile(filePath, os.O_RDONLY)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %s in %s", filePath, isoPath)
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// ListFiles returns the sorted paths of all the files of the iso
+func ListFiles(isoPath string) ([]string, error) {
+	sizes, err := ListFileSizes(isoPath)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(sizes))
+	for filePath := range sizes {
+		paths = append(paths, filePath)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// ListFileSizes returns the size of each file of the iso by path
+func ListFileSizes(isoPath string) (map[string]int64, error) {
+	d, err := diskfs.Open(isoPath, diskfs.WithOpenMode(diskfs.ReadOnly))
+	if err != nil {
+		return nil, err
+	}
+	defer d.File.Close()
+
+	fs, err := GetISO9660FileSystem(d)
+	if err != nil {
+		return nil, err
+	}
+
+	sizes := map[string]int64{}
+	if err := listAll(fs, "/", sizes); err != nil {
+		return nil, errors.Wrapf(err, "failed to list the files of %s", isoPath)
+	}
+	return sizes, nil
+}
+
+// recursive function adding the size of all the files from the given iso filesystem starting at fsDir to sizes
+func listAll(fs filesystem.FileSystem, fsDir string, sizes map[string]int64) error {
+	infos, err := fs.ReadDir(fsDir)
+	if err != nil {
+		return err
+	}
+	for _, info := range infos {
+		fsName := path.Join(fsDir, info.Name())
+		if info.IsDir() {
+			if err := listAll(fs, fsName, sizes); err != nil {
 				return err
 			}
+		} else {
+			sizes[fsName] = info.Size()
 		}
 	}
 	return nil
 }
 
-// Create builds an iso file at outPath with the given volumeLabel using the contents of the working directory
-func Create(outPath string, workDir string, volumeLabel string) error {
+// isSafeISOPath returns false for paths that try to leave the iso root
+func isSafeISOPath(p string) bool {
+	if p == "" {
+		return false
+	}
+	for _, element := range strings.Split(p, "/") {
+		if element == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// BootEntry describes an El Torito boot entry added to the ones created for the ISO
+type BootEntry struct {
+	Platform  iso9660.Platform
+	Emulation iso9660.Emulation
+	// BootFile is the path of the boot image relative to the root of the ISO
+	BootFile string
+}
+
+// DefaultBlockSize is the logical block size of the created ISOs
+const DefaultBlockSize = 2048
+
+// validBlockSizes are the logical block sizes supported for ISO9660 filesystems. diskfs can create
+// filesystems with larger blocks, but ISO9660 logical blocks can't be larger than the 2048 bytes sector
+// so firmwares and the kernel couldn't read them.
+var validBlockSizes = []int64{DefaultBlockSize}
+
+// Create builds an iso file at outPath with the given volumeLabel using the contents of the working directory.
+// See SetVolumeProvenance to record where it was built.
+func Create(outPath string, workDir string, volumeLabel string, bootEntries ...BootEntry) error {
+	return create(outPath, workDir, volumeLabel, DefaultBlockSize, bootEntries, nil)
+}
+
+// create creates the ISO, written through output when it isn't nil
+func create(outPath string, workDir string, volumeLabel string, blockSize int64, bootEntries []BootEntry, output *isoOutput) error {
+	if !funk.ContainsInt64(validBlockSizes, blockSize) {
+		return fmt.Errorf("unsupported block size %d, expected one of %v", blockSize, validBlockSizes)
+	}
+
 	// Use the minimum iso size that will satisfy diskfs validations here.
 	// This value doesn't determine the final image size, but is used
 	// to truncate the initial file. This value would be relevant if
@@ -99,23 +323,16 @@ func Create(outPath string, workDir string, volumeLabel string) error {
 		return err
 	}
 
-	d.LogicalBlocksize = 2048
-	fspec := disk.FilesystemSpec{
-		Partition:   0,
-		FSType:      filesystem.TypeISO9660,
-		VolumeLabel: volumeLabel,
-		WorkDir:     workDir,
+	var file util.File = d.File
+	if output != nil {
+		output.File = d.File
+		file = output
 	}
-	fs, err := d.CreateFilesystem(fspec)
+	iso, err := iso9660.Create(file, d.Size, 0, blockSize, workDir)
 	if err != nil {
 		return err
 	}
 
-	iso, ok := fs.(*iso9660.FileSystem)
-	if !ok {
-		return fmt.Errorf("not an iso9660 filesystem")
-	}
-
 	options := iso9660.FinalizeOptions{
 		RockRidge:        true,
 		VolumeIdentifier: volumeLabel,
@@ -190,17 +407,40 @@ func Create(outPath string, workDir string, volumeLabel string) error {
 		}
 	}
 
+	if len(bootEntries) > 0 {
+		if options.ElTorito == nil {
+			return fmt.Errorf("no El Torito boot catalog to add boot entries to")
+		}
+		for _, entry := range bootEntries {
+			loadSectors, err := fileLoadSectors(filepath.Join(workDir, entry.BootFile))
+			if err != nil {
+				return err
+			}
+			options.ElTorito.Entries = append(options.ElTorito.Entries, &iso9660.ElToritoEntry{
+				Platform:  entry.Platform,
+				Emulation: entry.Emulation,
+				BootFile:  entry.BootFile,
+				LoadSize:  loadSectors,
+			})
+		}
+	}
+
 	return iso.Finalize(options)
 }
 
 // Returns the number of sectors to load for efi boot
 // Load Sectors * 2048 should be the size of efiboot.img rounded up to a multiple of 2048
 func efiLoadSectors(workDir string) (uint16, error) {
-	efiStat, err := os.Stat(filepath.Join(workDir, "images/efiboot.img"))
+	return fileLoadSectors(filepath.Join(workDir, "images/efiboot.img"))
+}
+
+// Returns the number of 2048 byte sectors to load for the boot image
+func fileLoadSectors(path string) (uint16, error) {
+	stat, err := os.Stat(path)
 	if err != nil {
 		return 0, err
 	}
-	return uint16(math.Ceil(float64(efiStat.Size()) / 2048)), nil
+	return uint16(math.Ceil(float64(stat.Size()) / 2048)), nil
 }
 
 func cdbootLoadSectors(workDir string) (result uint16, err error) {
@@ -280,7 +520,67 @@ func VolumeIdentifier(isoPath string) (string, error) {
 		return "", err
 	}
 
-	return strings.TrimSpace(string(volumeId)), nil
+	// the identifier is padded with spaces, or with NULs by diskfs
+	return strings.TrimSpace(strings.TrimRight(string(volumeId), "\x00")), nil
+}
+
+// IsMinimalISO returns true if the ISO doesn't contain the rootfs image, as is the case for
+// ISOs created by CreateMinimalISO. ISOs without the /images/pxeboot directory aren't live ISOs,
+// minimal or not, so false is returned for them.
+func IsMinimalISO(isoPath string) (bool, error) {
+	d, err := diskfs.Open(isoPath, diskfs.WithOpenMode(diskfs.ReadOnly))
+	if err != nil {
+		return false, err
+	}
+	defer d.File.Close()
+
+	fs, err := GetISO9660FileSystem(d)
+	if err != nil {
+		return false, err
+	}
+
+	if !isoHasDir(fs, "/images/pxeboot") {
+		return false, nil
+	}
+	files, err := fs.ReadDir("/images/pxeboot")
+	if err != nil {
+		return false, errors.Wrap(err, "failed to read /images/pxeboot")
+	}
+	for _, f := range files {
+		if strings.EqualFold(f.Name(), "rootfs.img") {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// isoHasDir returns true if the directory exists in the iso filesystem
+func isoHasDir(fs filesystem.FileSystem, dirPath string) bool {
+	files, err := fs.ReadDir(path.Dir(path.Join("/", dirPath)))
+	if err != nil {
+		return false
+	}
+	for _, f := range files {
+		if f.IsDir() && strings.EqualFold(f.Name(), path.Base(dirPath)) {
+			return true
+		}
+	}
+	return false
+}
+
+// isoHasFile returns true if the file exists in the iso filesystem
+func isoHasFile(fs filesystem.FileSystem, filePath string) bool {
+	files, err := fs.ReadDir(path.Dir(path.Join("/", filePath)))
+	if err != nil {
+		return false
+	}
+	for _, f := range files {
+		if !f.IsDir() && strings.EqualFold(f.Name(), path.Base(filePath)) {
+			return true
+		}
+	}
+	return false
 }
 
 func GetISOFileInfo(filePath, isoPath string) (int64, int64, error) {
@@ -289,6 +589,8 @@ func GetISOFileInfo(filePath, isoPath string) (int64, int64, error) {
 		return 0, 0, err
 	}
 
+	defer d.File.Close()
+
 	fs, err := GetISO9660FileSystem(d)
 	if err != nil {
 		return 0, 0, err
@@ -301,8 +603,27 @@ func GetISOFileInfo(filePath, isoPath string) (int64, int64, error) {
 
 	defer fsFile.Close()
 	isoFile := fsFile.(*iso9660.File)
-	defaultSectorSize := uint32(2 * 1024)
-	return int64(isoFile.Location() * defaultSectorSize), isoFile.Size(), nil
+	// file locations are in logical blocks, which ISOs created by other tools or by older versions may
+	// not have of the default size
+	blockSize, err := logicalBlockSize(d.File)
+	if err != nil {
+		return 0, 0, err
+	}
+	return int64(isoFile.Location()) * blockSize, isoFile.Size(), nil
+}
+
+// logicalBlockSize returns the logical block size of the ISO, stored both little and big endian at offset
+// 128 of its primary volume descriptor
+func logicalBlockSize(iso io.ReaderAt) (int64, error) {
+	b := make([]byte, 2)
+	if _, err := iso.ReadAt(b, primaryVolumeDescriptorOffset+128); err != nil {
+		return 0, errors.Wrap(err, "failed to read the logical block size")
+	}
+	size := int64(binary.LittleEndian.Uint16(b))
+	if size == 0 {
+		return 0, fmt.Errorf("invalid logical block size 0")
+	}
+	return size, nil
 }
 
 // Gets a readWrite seeker of a specific file from the ISO image
@@ -340,5 +661,10 @@ func ReadFileFromISO(isoPath, filePath string) ([]byte, error) {
 
 // Gets directly the ISO 9660 filesystem (equivalent to GetFileSystem(0)).
 func GetISO9660FileSystem(d *disk.Disk) (filesystem.FileSystem, error) {
-	return iso9660.Read(d.File, d.Size, 0, 0)
+	// diskfs reads the directories with the block size it is given, not the one of the ISO
+	blockSize, err := logicalBlockSize(d.File)
+	if err != nil {
+		return nil, err
+	}
+	return iso9660.Read(d.File, d.Size, 0, blockSize)
 }
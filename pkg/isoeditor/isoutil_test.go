@@ -2,13 +2,16 @@ package isoeditor
 
 import (
 	"encoding/binary"
+	"errors"
 	"io"
 	"math"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	diskfs "github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/filesystem/iso9660"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 )
@@ -50,6 +53,118 @@ var _ = Context("with test files", func() {
 		})
 	})
 
+	Describe("ExtractExcept", func() {
+		It("extracts all the files but the skipped ones", func() {
+			dir, err := os.MkdirTemp("", "isotest")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+
+			Expect(ExtractExcept(isoFile, dir, "images/pxeboot/rootfs.img", "/isolinux/boot.cat")).To(Succeed())
+
+			Expect(filepath.Join(dir, "images/pxeboot/rootfs.img")).ToNot(BeAnExistingFile())
+			Expect(filepath.Join(dir, "isolinux/boot.cat")).ToNot(BeAnExistingFile())
+			validateFileContent(filepath.Join(dir, "images/pxeboot/vmlinuz"), "this is vmlinuz")
+			validateFileContent(filepath.Join(dir, "EFI/redhat/grub.cfg"), testGrubConfig)
+		})
+	})
+
+	Describe("ExtractFromReader", func() {
+		It("extracts the files read through ranged reads", func() {
+			dir, err := os.MkdirTemp("", "isotest")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+
+			f, err := os.Open(isoFile)
+			Expect(err).ToNot(HaveOccurred())
+			defer f.Close()
+			info, err := f.Stat()
+			Expect(err).ToNot(HaveOccurred())
+
+			r := &countingReaderAt{ReaderAt: f}
+			Expect(ExtractFromReader(r, info.Size(), dir, "images/efiboot.img")).To(Succeed())
+
+			Expect(filepath.Join(dir, "images/efiboot.img")).ToNot(BeAnExistingFile())
+			validateFileContent(filepath.Join(dir, "images/pxeboot/rootfs.img"), "this is rootfs")
+			validateFileContent(filepath.Join(dir, "EFI/redhat/grub.cfg"), testGrubConfig)
+			// the skipped efiboot.img is most of the iso
+			Expect(r.read).To(BeNumerically("<", info.Size()/2))
+		})
+	})
+
+	Describe("ExtractFiles", func() {
+		It("extracts the given files to their destinations", func() {
+			dir, err := os.MkdirTemp("", "isotest")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+
+			files := map[string]string{
+				"/EFI/redhat/grub.cfg":      filepath.Join(dir, "grub.cfg"),
+				"isolinux/isolinux.cfg":     filepath.Join(dir, "isolinux.cfg"),
+				"images/pxeboot/rootfs.img": filepath.Join(dir, "rootfs"),
+			}
+			Expect(ExtractFiles(isoFile, files)).To(Succeed())
+
+			validateFileContent(filepath.Join(dir, "grub.cfg"), testGrubConfig)
+			validateFileContent(filepath.Join(dir, "isolinux.cfg"), testISOLinuxConfig)
+			validateFileContent(filepath.Join(dir, "rootfs"), "this is rootfs")
+		})
+
+		It("fails for a missing file", func() {
+			dir, err := os.MkdirTemp("", "isotest")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+
+			err = ExtractFiles(isoFile, map[string]string{"/images/missing.img": filepath.Join(dir, "missing")})
+			Expect(err).To(MatchError(ContainSubstring("failed to extract /images/missing.img")))
+		})
+
+		It("rejects paths leaving the iso root", func() {
+			dir, err := os.MkdirTemp("", "isotest")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+
+			err = ExtractFiles(isoFile, map[string]string{"/images/../../etc/passwd": filepath.Join(dir, "passwd")})
+			Expect(err).To(MatchError(ContainSubstring("invalid path")))
+		})
+	})
+
+	Describe("ExtractFile", func() {
+		It("returns the content of a file", func() {
+			content, err := ExtractFile(isoFile, "EFI/redhat/grub.cfg")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(content)).To(Equal(testGrubConfig))
+		})
+
+		It("fails with a not found error for a missing file", func() {
+			_, err := ExtractFile(isoFile, "/images/missing.img")
+			Expect(err).To(MatchError(ContainSubstring("file /images/missing.img not found")))
+			Expect(errors.Is(err, os.ErrNotExist)).To(BeTrue())
+		})
+	})
+
+	Describe("ListFiles", func() {
+		It("returns the paths of all the files", func() {
+			files, err := ListFiles(isoFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(files).To(ContainElements(
+				"/EFI/redhat/grub.cfg",
+				"/coreos/igninfo.json",
+				"/images/pxeboot/rootfs.img",
+				"/isolinux/boot.cat",
+				"/isolinux/isolinux.cfg",
+			))
+			Expect(files).ToNot(ContainElement("/images/pxeboot"))
+			Expect(sort.StringsAreSorted(files)).To(BeTrue())
+		})
+
+		It("returns the size of the files", func() {
+			sizes, err := ListFileSizes(isoFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(sizes).To(HaveKeyWithValue("/images/pxeboot/rootfs.img", int64(len("this is rootfs"))))
+			Expect(sizes).To(HaveKeyWithValue("/isolinux/boot.cat", int64(0)))
+		})
+	})
+
 	Describe("Create", func() {
 		// SeekToBlock sets the offset for the next read to the beginning of the 2048 bytes block.
 		SeekToBlock := func(isoFD *os.File, block uint32) {
@@ -130,6 +245,37 @@ var _ = Context("with test files", func() {
 			return bootImageBytes
 		}
 
+		// ElToritoPlatforms returns the platform of every bootable entry of the El Torito boot catalog of an ISO file.
+		ElToritoPlatforms := func(isoFile string) []byte {
+			content, err := os.ReadFile(isoFile)
+			Expect(err).ToNot(HaveOccurred())
+
+			bootRecord := content[17*2048:]
+			Expect(strings.TrimRight(string(bootRecord[7:39]), "\x00")).To(Equal("EL TORITO SPECIFICATION"))
+			catalogBlock := binary.LittleEndian.Uint32(bootRecord[71:75])
+			catalog := content[catalogBlock*2048 : (catalogBlock+1)*2048]
+
+			// the validation entry holds the platform of the initial entry following it
+			Expect(catalog[0]).To(Equal(byte(1)))
+			Expect(catalog[32]).To(Equal(byte(0x88)))
+			platforms := []byte{catalog[1]}
+
+			// each section header is followed by its entries
+			for offset := 64; offset+32 <= len(catalog); {
+				header := catalog[offset : offset+32]
+				if header[0] != 0x90 && header[0] != 0x91 {
+					break
+				}
+				entries := int(binary.LittleEndian.Uint16(header[2:4]))
+				for i := 1; i <= entries; i++ {
+					Expect(catalog[offset+i*32]).To(Equal(byte(0x88)))
+					platforms = append(platforms, header[1])
+				}
+				offset += (entries + 1) * 32
+			}
+			return platforms
+		}
+
 		It("generates an iso with the content in the given directory", func() {
 			dir, err := os.MkdirTemp("", "isotest")
 			Expect(err).ToNot(HaveOccurred())
@@ -202,6 +348,61 @@ var _ = Context("with test files", func() {
 			Expect(Create(isoPath, filesDir, "my-vol")).To(Succeed())
 		})
 
+		It("uses the default logical block size", func() {
+			dir, err := os.MkdirTemp("", "isotest")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+			isoPath := filepath.Join(dir, "test.iso")
+
+			Expect(Create(isoPath, filesDir, "my-vol")).To(Succeed())
+
+			content, err := os.ReadFile(isoPath)
+			Expect(err).ToNot(HaveOccurred())
+			// the logical block size is stored both little and big endian at offset 128 of the primary volume descriptor
+			pvd := content[16*2048:]
+			Expect(pvd[0]).To(Equal(byte(1)))
+			Expect(binary.LittleEndian.Uint16(pvd[128:130])).To(Equal(uint16(DefaultBlockSize)))
+			Expect(binary.BigEndian.Uint16(pvd[130:132])).To(Equal(uint16(DefaultBlockSize)))
+		})
+
+		It("rejects an unsupported logical block size", func() {
+			dir, err := os.MkdirTemp("", "isotest")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+
+			err = create(filepath.Join(dir, "test.iso"), filesDir, "my-vol", 1000, nil, nil)
+			Expect(err).To(MatchError(ContainSubstring("unsupported block size 1000")))
+			// valid for diskfs but not readable as ISO9660 by firmwares and the kernel
+			err = create(filepath.Join(dir, "test.iso"), filesDir, "my-vol", 4096, nil, nil)
+			Expect(err).To(MatchError(ContainSubstring("unsupported block size 4096")))
+		})
+
+		It("adds boot entries to the existing ones", func() {
+			dir, err := os.MkdirTemp("", "isotest")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+			isoPath := filepath.Join(dir, "test.iso")
+			Expect(os.WriteFile(filepath.Join(filesDir, "images/efiboot-aa64.img"), make([]byte, 4096), 0600)).To(Succeed())
+
+			entry := BootEntry{Platform: iso9660.EFI, Emulation: iso9660.NoEmulation, BootFile: "images/efiboot-aa64.img"}
+			Expect(Create(isoPath, filesDir, "my-vol", entry)).To(Succeed())
+
+			Expect(ElToritoPlatforms(isoPath)).To(Equal([]byte{byte(iso9660.BIOS), byte(iso9660.EFI), byte(iso9660.EFI)}))
+		})
+
+		It("fails to add boot entries without boot files", func() {
+			dir, err := os.MkdirTemp("", "isotest")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+			isoPath := filepath.Join(dir, "test.iso")
+			Expect(os.Remove(filepath.Join(filesDir, "isolinux/isolinux.bin"))).To(Succeed())
+			Expect(os.Remove(filepath.Join(filesDir, "images/efiboot.img"))).To(Succeed())
+
+			entry := BootEntry{Platform: iso9660.EFI, Emulation: iso9660.NoEmulation, BootFile: "images/pxeboot/vmlinuz"}
+			err = Create(isoPath, filesDir, "my-vol", entry)
+			Expect(err).To(MatchError(ContainSubstring("no El Torito boot catalog")))
+		})
+
 		It("Preserves the El Torito boot image for s390x", func() {
 			// Create the input ISO:
 			var err error
@@ -357,6 +558,40 @@ var _ = Context("with test files", func() {
 		})
 	})
 
+	Describe("IsMinimalISO", func() {
+		It("returns false for a full iso", func() {
+			minimal, err := IsMinimalISO(isoFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(minimal).To(BeFalse())
+		})
+
+		It("returns true for an iso without rootfs", func() {
+			dir, err := os.MkdirTemp("", "isotest")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+			isoPath := filepath.Join(dir, "test.iso")
+			Expect(os.Remove(filepath.Join(filesDir, "images/pxeboot/rootfs.img"))).To(Succeed())
+			Expect(Create(isoPath, filesDir, "my-vol")).To(Succeed())
+
+			minimal, err := IsMinimalISO(isoPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(minimal).To(BeTrue())
+		})
+
+		It("returns false for an iso without the pxeboot directory", func() {
+			dir, err := os.MkdirTemp("", "isotest")
+			Expect(err).ToNot(HaveOccurred())
+			defer os.RemoveAll(dir)
+			isoPath := filepath.Join(dir, "test.iso")
+			Expect(os.RemoveAll(filepath.Join(filesDir, "images/pxeboot"))).To(Succeed())
+			Expect(Create(isoPath, filesDir, "my-vol")).To(Succeed())
+
+			minimal, err := IsMinimalISO(isoPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(minimal).To(BeFalse())
+		})
+	})
+
 	Describe("efiLoadSectors", func() {
 		It("returns the correct value", func() {
 			sectors, err := efiLoadSectors(filesDir)
@@ -365,3 +600,15 @@ var _ = Context("with test files", func() {
 		})
 	})
 })
+
+// countingReaderAt counts the bytes read through it
+type countingReaderAt struct {
+	io.ReaderAt
+	read int64
+}
+
+func (r *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := r.ReaderAt.ReadAt(p, off)
+	r.read += int64(n)
+	return n, err
+}
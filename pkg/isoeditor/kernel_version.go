@@ -0,0 +1,71 @@
+package isoeditor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"regexp"
+)
+
+// kernelPathsInISO are the paths of the kernel in the ISOs of the different archs
+var kernelPathsInISO = []string{"images/pxeboot/vmlinuz", "images/kernel.img"}
+
+const (
+	// offsets within the x86 boot protocol header of bzImage kernels
+	bzImageHeaderMagicOffset   = 0x202
+	bzImageKernelVersionOffset = 0x20e
+	bzImageSetupOffset         = 0x200
+)
+
+var linuxBannerRe = regexp.MustCompile(`Linux version (\S+)`)
+
+// KernelVersion returns the version of the kernel booted by the ISO, e.g. 4.18.0-305.19.1.el8_4.x86_64
+func KernelVersion(isoPath string) (string, error) {
+	for _, path := range kernelPathsInISO {
+		content, err := ReadFileFromISO(isoPath, "/"+path)
+		if err != nil {
+			continue
+		}
+		version, err := kernelVersion(content)
+		if err != nil {
+			return "", fmt.Errorf("failed to read the kernel version of %s: %w", path, err)
+		}
+		return version, nil
+	}
+	return "", fmt.Errorf("no kernel found in %s, possible paths are %v", isoPath, kernelPathsInISO)
+}
+
+// kernelVersion returns the version of the kernel image, read from the header of x86 bzImages
+// or from the Linux banner of other images, decompressing gzip compressed ones
+func kernelVersion(kernel []byte) (string, error) {
+	if len(kernel) > bzImageKernelVersionOffset+2 && string(kernel[bzImageHeaderMagicOffset:bzImageHeaderMagicOffset+4]) == "HdrS" {
+		offset := int(binary.LittleEndian.Uint16(kernel[bzImageKernelVersionOffset:])) + bzImageSetupOffset
+		if offset > bzImageSetupOffset && offset < len(kernel) {
+			version := kernel[offset:]
+			if end := bytes.IndexByte(version, 0); end >= 0 {
+				version = version[:end]
+			}
+			if fields := bytes.Fields(version); len(fields) > 0 {
+				return string(fields[0]), nil
+			}
+		}
+	}
+
+	if bytes.HasPrefix(kernel, gzipMagic) {
+		r, err := gzip.NewReader(bytes.NewReader(kernel))
+		if err != nil {
+			return "", err
+		}
+		if kernel, err = io.ReadAll(r); err != nil {
+			return "", err
+		}
+	}
+
+	match := linuxBannerRe.FindSubmatch(kernel)
+	if match == nil {
+		return "", fmt.Errorf("no kernel version found")
+	}
+	return string(match[1]), nil
+}
@@ -0,0 +1,87 @@
+package isoeditor
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+const testKernelVersion = "4.18.0-305.19.1.el8_4.x86_64"
+
+// testBzImage returns a kernel image with an x86 boot protocol header pointing at the version
+func testBzImage() []byte {
+	kernel := make([]byte, 0x1000)
+	copy(kernel[bzImageHeaderMagicOffset:], "HdrS")
+	binary.LittleEndian.PutUint16(kernel[bzImageKernelVersionOffset:], 0x400)
+	copy(kernel[0x600:], testKernelVersion+" (mockbuild@example.com) #1 SMP Wed Aug 11 13:53:27 EDT 2021")
+	return kernel
+}
+
+var _ = Describe("KernelVersion", func() {
+	var (
+		filesDir string
+		isoFile  string
+		workDir  string
+	)
+
+	BeforeEach(func() {
+		filesDir, isoFile = createTestFiles("Assisted123")
+
+		var err error
+		workDir, err = os.MkdirTemp("", "testkernelversion")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(filesDir)).To(Succeed())
+		Expect(os.Remove(isoFile)).To(Succeed())
+		Expect(os.RemoveAll(workDir)).To(Succeed())
+	})
+
+	createISO := func(kernelPath string, kernel []byte) string {
+		Expect(os.Remove(filepath.Join(filesDir, "images/pxeboot/vmlinuz"))).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(filesDir, kernelPath), kernel, 0600)).To(Succeed())
+		isoPath := filepath.Join(workDir, "kernel.iso")
+		Expect(Create(isoPath, filesDir, "Assisted123")).To(Succeed())
+		return isoPath
+	}
+
+	It("reads the version from the bzImage header", func() {
+		isoPath := createISO("images/pxeboot/vmlinuz", testBzImage())
+
+		version, err := KernelVersion(isoPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(version).To(Equal(testKernelVersion))
+	})
+
+	It("reads the version from the banner of a compressed kernel", func() {
+		var kernel bytes.Buffer
+		w := gzip.NewWriter(&kernel)
+		_, err := w.Write([]byte("\x00\x00Linux version 4.18.0-305.19.1.el8_4.aarch64 (mockbuild@example.com)\x00"))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(w.Close()).To(Succeed())
+		isoPath := createISO("images/pxeboot/vmlinuz", kernel.Bytes())
+
+		version, err := KernelVersion(isoPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(version).To(Equal("4.18.0-305.19.1.el8_4.aarch64"))
+	})
+
+	It("reads the s390x kernel", func() {
+		isoPath := createISO("images/kernel.img", []byte("Linux version 4.18.0-305.19.1.el8_4.s390x (mockbuild@example.com)"))
+
+		version, err := KernelVersion(isoPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(version).To(Equal("4.18.0-305.19.1.el8_4.s390x"))
+	})
+
+	It("fails without a version", func() {
+		_, err := KernelVersion(isoFile)
+		Expect(err).To(MatchError(ContainSubstring("no kernel version found")))
+	})
+})
@@ -0,0 +1,89 @@
+package isoeditor
+
+import (
+	"errors"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// StageDurationMetric is the histogram of the duration of the minimal ISO build stages
+	StageDurationMetric = "assisted_image_service_iso_build_stage_duration_seconds"
+	// StageFailuresMetric is the counter of the failed minimal ISO build stages
+	StageFailuresMetric = "assisted_image_service_iso_build_stage_failures_total"
+
+	// unknownArch labels the stages that run before the arch is detected
+	unknownArch = "unknown"
+)
+
+type buildMetrics struct {
+	stageDuration *prometheus.HistogramVec
+	stageFailures *prometheus.CounterVec
+}
+
+// WithMetricsRegisterer makes the build record the duration of its stages and the stages that failed,
+// labeled by stage and arch, in metrics registered with the registerer. Builds sharing a registerer
+// share the metrics, an editor registers them once when it is created.
+func WithMetricsRegisterer(registerer prometheus.Registerer) MinimalISOOption {
+	return func(o *minimalISOOptions) {
+		o.metricsRegisterer = registerer
+	}
+}
+
+// withBuildMetrics makes the build record its stages in the metrics already registered
+func withBuildMetrics(metrics *buildMetrics) MinimalISOOption {
+	return func(o *minimalISOOptions) {
+		o.metrics = metrics
+	}
+}
+
+func newBuildMetrics(registerer prometheus.Registerer, logger log.FieldLogger) *buildMetrics {
+	m := &buildMetrics{
+		stageDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    StageDurationMetric,
+			Help:    "Duration in seconds of the minimal ISO build stages",
+			Buckets: []float64{.1, 1, 10, 50, 100, 300, 600},
+		}, []string{"stage", "arch"}),
+		stageFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: StageFailuresMetric,
+			Help: "Number of failed minimal ISO build stages",
+		}, []string{"stage", "arch"}),
+	}
+	m.stageDuration = registerCollector(registerer, m.stageDuration, logger)
+	m.stageFailures = registerCollector(registerer, m.stageFailures, logger)
+	return m
+}
+
+// registerCollector registers the collector, returning the one already registered by a previous build
+// if any. A collector that can't be registered is still returned so recording doesn't need to check it.
+func registerCollector[C prometheus.Collector](registerer prometheus.Registerer, collector C, logger log.FieldLogger) C {
+	err := registerer.Register(collector)
+	if err == nil {
+		return collector
+	}
+	var registered prometheus.AlreadyRegisteredError
+	if errors.As(err, &registered) {
+		if existing, ok := registered.ExistingCollector.(C); ok {
+			return existing
+		}
+	}
+	logger.WithError(err).Error("Failed to register the minimal ISO build metrics")
+	return collector
+}
+
+// recordStageMetrics records the stage in the build metrics, if the build has any
+func (o *minimalISOOptions) recordStageMetrics(stage string, duration time.Duration, err error) {
+	if o.metrics == nil {
+		return
+	}
+	arch := o.arch
+	if arch == "" {
+		arch = unknownArch
+	}
+	o.metrics.stageDuration.WithLabelValues(stage, arch).Observe(duration.Seconds())
+	if err != nil {
+		o.metrics.stageFailures.WithLabelValues(stage, arch).Inc()
+	}
+}
@@ -0,0 +1,141 @@
+package isoeditor
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+)
+
+var _ = Describe("metrics", func() {
+	var (
+		filesDir       string
+		isoFile        string
+		workDir        string
+		minimalISOPath string
+		registry       *prometheus.Registry
+	)
+
+	BeforeEach(func() {
+		filesDir, isoFile = createTestFiles("Assisted123")
+
+		var err error
+		workDir, err = os.MkdirTemp("", "testmetrics")
+		Expect(err).NotTo(HaveOccurred())
+		minimalISOPath = filepath.Join(workDir, "minimal.iso")
+		registry = prometheus.NewRegistry()
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(filesDir)).To(Succeed())
+		Expect(os.Remove(isoFile)).To(Succeed())
+		Expect(os.RemoveAll(workDir)).To(Succeed())
+	})
+
+	It("observes the duration of the stages of a build", func() {
+		editor := NewEditor(workDir, WithMetricsRegisterer(registry))
+		Expect(editor.CreateMinimalISOTemplate(context.Background(), isoFile, testRootFSURL, "x86_64", minimalISOPath)).To(Succeed())
+		// the second build records into the metrics registered by the first one
+		Expect(editor.CreateMinimalISOTemplate(context.Background(), isoFile, testRootFSURL, "x86_64", minimalISOPath)).To(Succeed())
+
+		Expect(gatherMetric(registry, StageDurationMetric)).To(Equal(map[string]float64{
+			StageExtract + "/x86_64":           2,
+			StageVolumeIdentifier + "/x86_64":  2,
+			StageRemoveRootFS + "/x86_64":      2,
+			StageEmbedPlaceholders + "/x86_64": 2,
+			StageGrubConfig + "/x86_64":        2,
+			StageIsolinuxConfig + "/x86_64":    2,
+			StageCreate + "/x86_64":            2,
+		}))
+		Expect(gatherMetric(registry, StageFailuresMetric)).To(BeEmpty())
+	})
+
+	It("counts the failed stages by arch", func() {
+		editor := NewEditor(workDir, WithMetricsRegisterer(registry))
+		err := editor.CreateMinimalISOTemplate(context.Background(), "invalid", testRootFSURL, "x86_64", minimalISOPath)
+		Expect(err).To(HaveOccurred())
+
+		Expect(gatherMetric(registry, StageFailuresMetric)).To(Equal(map[string]float64{StageExtract + "/x86_64": 1}))
+	})
+
+	It("labels the stages run before the arch is detected", func() {
+		err := CreateMinimalISOFromDir(filesDir, "Assisted123", testRootFSURL, "", minimalISOPath, WithMetricsRegisterer(registry))
+		Expect(err).ToNot(HaveOccurred())
+
+		durations := gatherMetric(registry, StageDurationMetric)
+		Expect(durations).To(HaveKeyWithValue(StageDetectArch+"/"+unknownArch, float64(1)))
+		Expect(durations).To(HaveKeyWithValue(StageCreate+"/x86_64", float64(1)))
+	})
+
+	It("registers the metrics once per editor", func() {
+		registerer := &countingRegisterer{Registerer: registry}
+		editor := NewEditor(workDir, WithMetricsRegisterer(registerer))
+		Expect(registerer.registered).To(Equal(2))
+
+		Expect(editor.CreateMinimalISOTemplate(context.Background(), isoFile, testRootFSURL, "x86_64", minimalISOPath)).To(Succeed())
+		Expect(editor.CreateMinimalISOTemplate(context.Background(), isoFile, testRootFSURL, "x86_64", minimalISOPath)).To(Succeed())
+		Expect(registerer.registered).To(Equal(2))
+		Expect(gatherMetric(registry, StageDurationMetric)).To(HaveKeyWithValue(StageCreate+"/x86_64", float64(2)))
+	})
+
+	It("logs registration failures with the build logger", func() {
+		logger, hook := logtest.NewNullLogger()
+		registerer := &countingRegisterer{Registerer: registry, err: errors.New("registration failed")}
+		editor := NewEditor(workDir, WithMetricsRegisterer(registerer), WithLogger(logger))
+		Expect(hook.AllEntries()).To(HaveLen(2))
+		Expect(hook.LastEntry().Message).To(Equal("Failed to register the minimal ISO build metrics"))
+
+		// the build still records in the unregistered metrics
+		Expect(editor.CreateMinimalISOTemplate(context.Background(), isoFile, testRootFSURL, "x86_64", minimalISOPath)).To(Succeed())
+	})
+
+	It("doesn't need a registerer", func() {
+		editor := NewEditor(workDir)
+		Expect(editor.CreateMinimalISOTemplate(context.Background(), isoFile, testRootFSURL, "x86_64", minimalISOPath)).To(Succeed())
+	})
+})
+
+// gatherMetric returns the sample count of the histogram, or the value of the counter, by stage/arch labels
+func gatherMetric(registry *prometheus.Registry, name string) map[string]float64 {
+	families, err := registry.Gather()
+	Expect(err).ToNot(HaveOccurred())
+	values := map[string]float64{}
+	for _, family := range families {
+		if family.GetName() != name {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			key := labels["stage"] + "/" + labels["arch"]
+			if histogram := metric.GetHistogram(); histogram != nil {
+				values[key] = float64(histogram.GetSampleCount())
+			} else {
+				values[key] = metric.GetCounter().GetValue()
+			}
+		}
+	}
+	return values
+}
+
+// countingRegisterer counts the collectors registered, failing with err when it is set
+type countingRegisterer struct {
+	prometheus.Registerer
+	registered int
+	err        error
+}
+
+func (r *countingRegisterer) Register(collector prometheus.Collector) error {
+	r.registered++
+	if r.err != nil {
+		return r.err
+	}
+	return r.Registerer.Register(collector)
+}
@@ -5,6 +5,8 @@
 package isoeditor
 
 import (
+	context "context"
+	io "io"
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
@@ -33,16 +35,44 @@ func (m *MockEditor) EXPECT() *MockEditorMockRecorder {
 	return m.recorder
 }
 
+// Close mocks base method.
+func (m *MockEditor) Close() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Close")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Close indicates an expected call of Close.
+func (mr *MockEditorMockRecorder) Close() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Close", reflect.TypeOf((*MockEditor)(nil).Close))
+}
+
 // CreateMinimalISOTemplate mocks base method.
-func (m *MockEditor) CreateMinimalISOTemplate(arg0, arg1, arg2, arg3 string) error {
+func (m *MockEditor) CreateMinimalISOTemplate(arg0 context.Context, arg1, arg2, arg3, arg4 string) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CreateMinimalISOTemplate", arg0, arg1, arg2, arg3)
+	ret := m.ctrl.Call(m, "CreateMinimalISOTemplate", arg0, arg1, arg2, arg3, arg4)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // CreateMinimalISOTemplate indicates an expected call of CreateMinimalISOTemplate.
-func (mr *MockEditorMockRecorder) CreateMinimalISOTemplate(arg0, arg1, arg2, arg3 interface{}) *gomock.Call {
+func (mr *MockEditorMockRecorder) CreateMinimalISOTemplate(arg0, arg1, arg2, arg3, arg4 interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateMinimalISOTemplate", reflect.TypeOf((*MockEditor)(nil).CreateMinimalISOTemplate), arg0, arg1, arg2, arg3, arg4)
+}
+
+// CreateMinimalISOTemplateFromReader mocks base method.
+func (m *MockEditor) CreateMinimalISOTemplateFromReader(arg0 context.Context, arg1 io.ReaderAt, arg2 int64, arg3, arg4, arg5 string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateMinimalISOTemplateFromReader", arg0, arg1, arg2, arg3, arg4, arg5)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateMinimalISOTemplateFromReader indicates an expected call of CreateMinimalISOTemplateFromReader.
+func (mr *MockEditorMockRecorder) CreateMinimalISOTemplateFromReader(arg0, arg1, arg2, arg3, arg4, arg5 interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateMinimalISOTemplate", reflect.TypeOf((*MockEditor)(nil).CreateMinimalISOTemplate), arg0, arg1, arg2, arg3)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateMinimalISOTemplateFromReader", reflect.TypeOf((*MockEditor)(nil).CreateMinimalISOTemplateFromReader), arg0, arg1, arg2, arg3, arg4, arg5)
 }
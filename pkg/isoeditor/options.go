@@ -0,0 +1,611 @@
+package isoeditor
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	log "github.com/sirupsen/logrus"
+)
+
+// DefaultRootFSArgKey is the kernel argument used by coreos to download the rootfs
+const DefaultRootFSArgKey = "coreos.live.rootfs_url"
+
+// DefaultIgnitionPlatformID is the ignition platform id of bare metal installs
+const DefaultIgnitionPlatformID = "metal"
+
+// MinimalISOOption customizes how a minimal ISO is built
+type MinimalISOOption func(*minimalISOOptions)
+
+type minimalISOOptions struct {
+	// ctx is the context of the build, stages don't start once it is done
+	ctx           context.Context
+	buildTimeout  time.Duration
+	tempDir       string
+	logger        log.FieldLogger
+	logFieldNames LogFieldNames
+
+	events   chan<- BuildEvent
+	progress ProgressFunc
+	// progressDone is the sum of the stageWeights of the stages done
+	progressDone float64
+	bootTimeouts map[string]time.Duration
+
+	rootFSArgKey      string
+	rootFSDeviceLabel string
+	rootFSURLSchemes  []string
+	embedRootFS       bool
+
+	checksumAlgorithm ChecksumAlgorithm
+	checksum          bool
+	checksumSidecar   bool
+
+	// arch is the arch of the build once known, for the metrics labels
+	arch              string
+	metricsRegisterer prometheus.Registerer
+	metrics           *buildMetrics
+
+	// buildReport enables recording the stageTimings and writing the build report
+	buildReport  bool
+	stageTimings []StageTiming
+
+	sourceDateEpoch *time.Time
+	provenance      *VolumeProvenance
+	volumeID        string
+	checkVolumeID   bool
+	validateISO     bool
+
+	bootEntries []BootEntry
+	// placeholderFiles are archived into the custom ramdisk image placeholder
+	placeholderFiles map[string][]byte
+	// placeholderLength is the size of the custom ramdisk image placeholder
+	placeholderLength uint64
+	blockSize         int64
+
+	copyNetworkArg     string
+	ignitionPlatformID string
+	addedKernelArgs    []string
+	removedKernelArgs  []string
+	initrdPosition     *InitrdPosition
+	// extraInitrdImages are added in order after the custom ramdisk image
+	extraInitrdImages []string
+
+	postProcessors []PostProcessor
+
+	// dryRun is filled instead of creating the minimal ISO
+	dryRun *DryRunReport
+	// result is filled once the minimal ISO is built
+	result *BuildResult
+}
+
+func newMinimalISOOptions(opts ...MinimalISOOption) *minimalISOOptions {
+	o := &minimalISOOptions{
+		ctx:                context.Background(),
+		logger:             log.StandardLogger(),
+		logFieldNames:      DefaultLogFieldNames,
+		rootFSArgKey:       DefaultRootFSArgKey,
+		rootFSURLSchemes:   DefaultRootFSURLSchemes,
+		checksumAlgorithm:  DefaultChecksumAlgorithm,
+		blockSize:          DefaultBlockSize,
+		ignitionPlatformID: DefaultIgnitionPlatformID,
+		placeholderLength:  RamDiskPaddingLength,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.metrics == nil && o.metricsRegisterer != nil {
+		o.metrics = newBuildMetrics(o.metricsRegisterer, o.logger)
+	}
+	return o
+}
+
+// WithBuildEvents makes the build send BuildEvents to the given channel.
+// Sends never block, events are dropped if the channel is not ready to receive them.
+func WithBuildEvents(events chan<- BuildEvent) MinimalISOOption {
+	return func(o *minimalISOOptions) {
+		o.events = events
+	}
+}
+
+// WithProgress calls the function from the build goroutine as each stage starts, completes or fails,
+// see ProgressFunc. A nil function is ignored.
+func WithProgress(progress ProgressFunc) MinimalISOOption {
+	return func(o *minimalISOOptions) {
+		o.progress = progress
+	}
+}
+
+// WithLogger sends the build logs to the given logger instead of the standard logrus logger,
+// e.g. an entry with fields identifying the request that triggered the build
+func WithLogger(logger log.FieldLogger) MinimalISOOption {
+	return func(o *minimalISOOptions) {
+		if logger != nil {
+			o.logger = logger
+		}
+	}
+}
+
+// LogFieldNames are the keys of the fields added to the build logs
+type LogFieldNames struct {
+	// Stage is the key of the build stage, see StageExtract and the other stages
+	Stage string
+	// WorkDir is the key of the directory the ISO was extracted to
+	WorkDir string
+	// Path is the key of the file the log is about
+	Path string
+}
+
+// DefaultLogFieldNames are the log field keys used unless WithLogFieldNames is given
+var DefaultLogFieldNames = LogFieldNames{
+	Stage:   "stage",
+	WorkDir: "workDir",
+	Path:    "path",
+}
+
+// WithLogFieldNames sets the keys of the fields added to the build logs, e.g. to match the schema
+// of a log pipeline. Empty names keep their default.
+func WithLogFieldNames(names LogFieldNames) MinimalISOOption {
+	return func(o *minimalISOOptions) {
+		if names.Stage != "" {
+			o.logFieldNames.Stage = names.Stage
+		}
+		if names.WorkDir != "" {
+			o.logFieldNames.WorkDir = names.WorkDir
+		}
+		if names.Path != "" {
+			o.logFieldNames.Path = names.Path
+		}
+	}
+}
+
+// stageLogger returns the logger with the fields of the stage working on extractDir
+func (o *minimalISOOptions) stageLogger(stage, extractDir string) log.FieldLogger {
+	return o.logger.WithFields(log.Fields{
+		o.logFieldNames.Stage:   stage,
+		o.logFieldNames.WorkDir: extractDir,
+	})
+}
+
+// pathLogger returns the logger with the field of the given file
+func (o *minimalISOOptions) pathLogger(path string) log.FieldLogger {
+	return o.logger.WithField(o.logFieldNames.Path, path)
+}
+
+// WithBuildTimeout bounds how long a build runs. Once the timeout elapsed stages don't start, and the
+// extraction of the full ISO, its copy from a reader and the creation of the minimal ISO fail on their
+// next read or write. With NewEditor it applies to each CreateMinimalISOTemplate call, on top of its context.
+func WithBuildTimeout(timeout time.Duration) MinimalISOOption {
+	return func(o *minimalISOOptions) {
+		o.buildTimeout = timeout
+	}
+}
+
+// startBuild sets the build timeout on the build context, the returned func releases it
+func (o *minimalISOOptions) startBuild() context.CancelFunc {
+	if o.buildTimeout <= 0 {
+		return func() {}
+	}
+	var cancel context.CancelFunc
+	o.ctx, cancel = context.WithTimeout(o.ctx, o.buildTimeout)
+	return cancel
+}
+
+// WithTempDir sets the directory the temporary directories of the builds are created in, e.g. the
+// extracted ISOs, instead of the data dir of NewEditor or, for the builds without an editor, os.TempDir
+func WithTempDir(dir string) MinimalISOOption {
+	return func(o *minimalISOOptions) {
+		o.tempDir = dir
+	}
+}
+
+// WithBootTimeouts sets the boot menu timeout per architecture. The timeout is
+// applied to the grub config and, for architectures using it, to the isolinux config.
+// Architectures missing from the map keep the timeouts of the source ISO.
+func WithBootTimeouts(timeouts map[string]time.Duration) MinimalISOOption {
+	return func(o *minimalISOOptions) {
+		o.bootTimeouts = timeouts
+	}
+}
+
+// WithRootFSArgKey sets the kernel argument used to pass the rootfs URL,
+// for installers that don't use coreos.live.rootfs_url
+func WithRootFSArgKey(key string) MinimalISOOption {
+	return func(o *minimalISOOptions) {
+		o.rootFSArgKey = key
+	}
+}
+
+// WithRootFSDeviceLabel makes the minimal ISO load the rootfs from a local device with
+// the given volume label (e.g. the full ISO attached as a second disk) instead of
+// downloading it from the rootfs URL. The coreos.liveiso kernel argument is set to
+// the label and coreos.live.rootfs_url is not added.
+func WithRootFSDeviceLabel(label string) MinimalISOOption {
+	return func(o *minimalISOOptions) {
+		o.rootFSDeviceLabel = label
+	}
+}
+
+// DefaultRootFSURLSchemes are the schemes accepted for rootfs URLs
+var DefaultRootFSURLSchemes = []string{"http", "https"}
+
+// WithAdditionalRootFSURLSchemes accepts rootfs URLs with the given schemes on top of the default ones,
+// e.g. file or tftp for disconnected environments
+func WithAdditionalRootFSURLSchemes(schemes ...string) MinimalISOOption {
+	return func(o *minimalISOOptions) {
+		o.rootFSURLSchemes = append(append([]string{}, o.rootFSURLSchemes...), schemes...)
+	}
+}
+
+// WithRootFSURLSchemes replaces the schemes accepted for rootfs URLs, e.g. with a custom scheme
+// understood by the installer. URLs with other schemes are still checked to be well formed.
+func WithRootFSURLSchemes(schemes ...string) MinimalISOOption {
+	return func(o *minimalISOOptions) {
+		o.rootFSURLSchemes = schemes
+	}
+}
+
+// validateRootFSURL checks that the rootfs URL is well formed with one of the accepted schemes.
+// It isn't used when the rootfs is loaded from a device or embedded.
+func (o *minimalISOOptions) validateRootFSURL(rootFSURL string) error {
+	if o.rootFSDeviceLabel != "" || o.embedRootFS {
+		return nil
+	}
+	if strings.ContainsAny(rootFSURL, " \t\n") {
+		return fmt.Errorf("invalid rootfs URL %q: contains whitespace", rootFSURL)
+	}
+	u, err := url.Parse(rootFSURL)
+	if err != nil {
+		return fmt.Errorf("invalid rootfs URL %q: %w", rootFSURL, err)
+	}
+	scheme := strings.ToLower(u.Scheme)
+	accepted := false
+	for _, s := range o.rootFSURLSchemes {
+		if strings.EqualFold(s, scheme) {
+			accepted = true
+			break
+		}
+	}
+	if !accepted {
+		return fmt.Errorf("invalid rootfs URL %q: scheme must be one of %v", rootFSURL, o.rootFSURLSchemes)
+	}
+	if (scheme == "http" || scheme == "https") && u.Host == "" {
+		return fmt.Errorf("invalid rootfs URL %q: missing host", rootFSURL)
+	}
+	if u.Host == "" && u.Path == "" {
+		return fmt.Errorf("invalid rootfs URL %q: missing host and path", rootFSURL)
+	}
+	return nil
+}
+
+// rootFSArg returns the kernel argument telling the live system where to find the rootfs
+func (o *minimalISOOptions) rootFSArg(rootFSURL string) string {
+	if o.rootFSDeviceLabel != "" {
+		return fmt.Sprintf("coreos.liveiso=%s", o.rootFSDeviceLabel)
+	}
+	return fmt.Sprintf("%s=%s", o.rootFSArgKey, rootFSURL)
+}
+
+// WithEmbeddedRootFS keeps the rootfs in the ISO so that it boots offline. The boot configs are edited
+// as for a minimal ISO, but they keep finding the rootfs through coreos.liveiso and get no rootfs URL,
+// the rootfs URL of the build is ignored.
+func WithEmbeddedRootFS() MinimalISOOption {
+	return func(o *minimalISOOptions) {
+		o.embedRootFS = true
+	}
+}
+
+// skippedFiles returns the files of the full ISO the build doesn't need to extract
+func (o *minimalISOOptions) skippedFiles() []string {
+	// the rootfs is removed from minimal ISOs, don't spend time and disk space extracting it
+	if o.embedRootFS {
+		return nil
+	}
+	return []string{rootFSPathInISO}
+}
+
+// WithChecksumAlgorithm selects the algorithm used for all the checksums computed during the build
+func WithChecksumAlgorithm(algorithm ChecksumAlgorithm) MinimalISOOption {
+	return func(o *minimalISOOptions) {
+		o.checksumAlgorithm = algorithm
+	}
+}
+
+// WithChecksum computes the checksum and size of the minimal ISO, they are set in the completed
+// build event and the build report
+func WithChecksum() MinimalISOOption {
+	return func(o *minimalISOOptions) {
+		o.checksum = true
+	}
+}
+
+// WithChecksumSidecar writes the checksum of the minimal ISO to a file next to it,
+// see ChecksumSidecarPath
+func WithChecksumSidecar() MinimalISOOption {
+	return func(o *minimalISOOptions) {
+		o.checksumSidecar = true
+	}
+}
+
+// WithBuildReport writes a JSON report of the build next to the minimal ISO, see BuildReportPath
+// and ReadBuildReport. It is written before the post-processors run.
+func WithBuildReport() MinimalISOOption {
+	return func(o *minimalISOOptions) {
+		o.buildReport = true
+	}
+}
+
+// WithSourceDateEpoch sets all the file timestamps and volume dates of the minimal ISO
+// to the given time instead of the build time, see ReproducibleCreateMinimalISO
+func WithSourceDateEpoch(epoch time.Time) MinimalISOOption {
+	return func(o *minimalISOOptions) {
+		o.sourceDateEpoch = &epoch
+	}
+}
+
+// WithVolumeProvenance sets the publisher and data preparer identifiers of the minimal ISO
+func WithVolumeProvenance(provenance VolumeProvenance) MinimalISOOption {
+	return func(o *minimalISOOptions) {
+		o.provenance = &provenance
+	}
+}
+
+// MaxVolumeIDLength is the length of the volume identifier field of ISO 9660 volumes
+const MaxVolumeIDLength = 32
+
+// WithVolumeID sets the volume identifier of the minimal ISO instead of the one of the source ISO.
+// Only letters, digits, '_', '.' and '-' are allowed, identifiers longer than MaxVolumeIDLength
+// are truncated with a warning.
+func WithVolumeID(id string) MinimalISOOption {
+	return func(o *minimalISOOptions) {
+		o.volumeID = id
+	}
+}
+
+// resolveVolumeID returns the volume identifier of the minimal ISO, the overridden one or sourceID,
+// and the warning raised when it had to be truncated
+func (o *minimalISOOptions) resolveVolumeID(sourceID string) (string, []BuildWarning, error) {
+	volumeID := sourceID
+	if o.volumeID != "" {
+		for _, c := range o.volumeID {
+			if !isVolumeIDChar(c) {
+				return "", nil, fmt.Errorf("invalid volume identifier %q: illegal character %q", o.volumeID, c)
+			}
+		}
+		volumeID = o.volumeID
+	}
+	if len(volumeID) <= MaxVolumeIDLength {
+		return volumeID, nil, nil
+	}
+	truncated := volumeID[:MaxVolumeIDLength]
+	return truncated, []BuildWarning{{
+		Kind:    BuildWarningVolumeIDTruncated,
+		Message: fmt.Sprintf("volume identifier %q is longer than %d characters, truncated to %q", volumeID, MaxVolumeIDLength, truncated),
+	}}, nil
+}
+
+func isVolumeIDChar(c rune) bool {
+	return (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '_' || c == '.' || c == '-'
+}
+
+// WithISOValidation makes CreateMinimalISOTemplate check the source with ValidateISO before
+// extracting it, so the wrong ISO fails early with the elements it's missing
+func WithISOValidation() MinimalISOOption {
+	return func(o *minimalISOOptions) {
+		o.validateISO = true
+	}
+}
+
+// WithVolumeIDCheck makes the build check that the minimal ISO got the volume identifier of the
+// source ISO, the build fails before the minimal ISO is moved to its path otherwise
+func WithVolumeIDCheck() MinimalISOOption {
+	return func(o *minimalISOOptions) {
+		o.checkVolumeID = true
+	}
+}
+
+// WithBootEntries adds El Torito boot entries to the minimal ISO, e.g. an arm64 EFI image for
+// media booting on both x86_64 and arm64. The entries of the source ISO are preserved.
+func WithBootEntries(entries ...BootEntry) MinimalISOOption {
+	return func(o *minimalISOOptions) {
+		o.bootEntries = append(o.bootEntries, entries...)
+	}
+}
+
+// WithPlaceholderFiles fills the custom ramdisk image placeholder with a gzip compressed CPIO archive
+// of the files instead of zeros. The archive is reproducible and must fit in the placeholder.
+func WithPlaceholderFiles(files map[string][]byte) MinimalISOOption {
+	return func(o *minimalISOOptions) {
+		o.placeholderFiles = files
+	}
+}
+
+// WithPlaceholderLength sets the size of the custom ramdisk image placeholder, RamDiskPaddingLength by
+// default. The content later written to the placeholder by the image stream must fit in it.
+func WithPlaceholderLength(length uint64) MinimalISOOption {
+	return func(o *minimalISOOptions) {
+		o.placeholderLength = length
+	}
+}
+
+// placeholderContent returns the content of the custom ramdisk image placeholder, nil for zeros only
+func (o *minimalISOOptions) placeholderContent() ([]byte, error) {
+	if o.placeholderLength == 0 {
+		return nil, fmt.Errorf("invalid placeholder length 0")
+	}
+	if len(o.placeholderFiles) == 0 {
+		return nil, nil
+	}
+	archive, err := cpioArchive(o.placeholderFiles, ArchiveCompressionGzip)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(archive.Len()) > o.placeholderLength {
+		return nil, fmt.Errorf("placeholder content is %d bytes, the placeholder holds %d", archive.Len(), o.placeholderLength)
+	}
+	return archive.Bytes(), nil
+}
+
+// WithBlockSize sets the logical block size of the minimal ISO. Only DefaultBlockSize is valid for ISO9660,
+// any other size fails the build.
+func WithBlockSize(size int64) MinimalISOOption {
+	return func(o *minimalISOOptions) {
+		o.blockSize = size
+	}
+}
+
+// WithCopyNetworkArg adds the kernel argument making coreos-installer copy the network config of the
+// live system to the installed system. The argument must be one of the forms coreos-installer accepts,
+// e.g. coreos.inst.copy_network.
+func WithCopyNetworkArg(arg string) MinimalISOOption {
+	return func(o *minimalISOOptions) {
+		o.copyNetworkArg = arg
+	}
+}
+
+// WithIgnitionPlatformID sets the value of the ignition.platform.id kernel argument added to the boot
+// configs, see DefaultIgnitionPlatformID. A boot config setting it to another value is left untouched
+// with a KernelArgKept warning.
+func WithIgnitionPlatformID(id string) MinimalISOOption {
+	return func(o *minimalISOOptions) {
+		o.ignitionPlatformID = id
+	}
+}
+
+// InitrdPosition is the position of the custom ramdisk image, followed by the extra initrd images,
+// among the initrd images of the boot configs. The order of the images matters since the files of the later ones replace those of the
+// earlier ones
+type InitrdPosition struct {
+	// Index is the position of the custom ramdisk image among the existing images, used when Image is empty
+	Index int
+	// Image is the path or file name of the existing image the custom ramdisk image is inserted before
+	Image string
+	// After inserts the custom ramdisk image after Image instead of before it
+	After bool
+}
+
+// WithInitrdPosition inserts the custom ramdisk image at the position instead of after all the
+// existing initrd images. The build fails when the position doesn't exist in a boot config.
+func WithInitrdPosition(position InitrdPosition) MinimalISOOption {
+	return func(o *minimalISOOptions) {
+		o.initrdPosition = &position
+	}
+}
+
+// insert returns the images with the inserted ones at the position, or appended for a nil position
+func (p *InitrdPosition) insert(images []string, inserted []string) ([]string, error) {
+	index := len(images)
+	if p != nil && p.Image == "" {
+		if p.Index < 0 || p.Index > len(images) {
+			return nil, fmt.Errorf("initrd position %d is out of range, there are %d initrd images", p.Index, len(images))
+		}
+		index = p.Index
+	} else if p != nil {
+		index = -1
+		for i, existing := range images {
+			if existing == p.Image || path.Base(existing) == p.Image {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			return nil, fmt.Errorf("initrd image %q not found in %v", p.Image, images)
+		}
+		if p.After {
+			index++
+		}
+	}
+
+	result := append([]string{}, images[:index]...)
+	result = append(result, inserted...)
+	return append(result, images[index:]...), nil
+}
+
+// WithExtraInitrdImages adds the images, e.g. a static network config ramdisk, to the initrd images
+// of the boot configs in order after the custom ramdisk image. The paths are absolute paths within
+// the ISO and the images must be in the extract dir.
+func WithExtraInitrdImages(paths ...string) MinimalISOOption {
+	return func(o *minimalISOOptions) {
+		o.extraInitrdImages = append(o.extraInitrdImages, paths...)
+	}
+}
+
+// initrdImages returns the images the minimal ISO adds to the initrd images of the boot configs
+func (o *minimalISOOptions) initrdImages() []string {
+	return append([]string{ramDiskImagePath}, o.extraInitrdImages...)
+}
+
+func (o *minimalISOOptions) validateExtraInitrdImages() error {
+	seen := map[string]bool{ramDiskImagePath: true}
+	for _, image := range o.extraInitrdImages {
+		if !strings.HasPrefix(image, "/") || strings.ContainsAny(image, " \t\n',") {
+			return fmt.Errorf("invalid extra initrd image path %q", image)
+		}
+		if seen[image] {
+			return fmt.Errorf("initrd image %s is added twice", image)
+		}
+		seen[image] = true
+	}
+	return nil
+}
+
+// PostProcessor runs on a built minimal ISO, e.g. to sign or upload it
+type PostProcessor func(isoPath string) error
+
+// WithPostProcessors runs the post-processors in order on the minimal ISO once it is at its final
+// path and its checksum file is written. The build fails on the first post-processor failing, the
+// minimal ISO is left in place.
+func WithPostProcessors(postProcessors ...PostProcessor) MinimalISOOption {
+	return func(o *minimalISOOptions) {
+		o.postProcessors = append(o.postProcessors, postProcessors...)
+	}
+}
+
+// kernelArgs returns the kernel arguments the boot configs of the minimal ISO must have, but the ones
+// removed or set by the caller
+func (o *minimalISOOptions) kernelArgs() []string {
+	args := ignitionArgs(o.ignitionPlatformID)
+	if o.copyNetworkArg != "" {
+		args = append(args, o.copyNetworkArg)
+	}
+	var kept []string
+	for _, arg := range args {
+		if !matchesKernelArg(arg, o.removedKernelArgs) && !matchesKernelArg(arg, kernelArgKeys(o.addedKernelArgs)) {
+			kept = append(kept, arg)
+		}
+	}
+	return kept
+}
+
+// WithAddedKernelArgs sets the kernel arguments in the boot configs of the minimal ISO, e.g. ip= and
+// rd.neednet=1 for static networking. The arguments already present with any value are replaced, several
+// arguments with the same key are all kept.
+func WithAddedKernelArgs(args ...string) MinimalISOOption {
+	return func(o *minimalISOOptions) {
+		o.addedKernelArgs = append(o.addedKernelArgs, args...)
+	}
+}
+
+// WithRemovedKernelArgs removes kernel arguments from the boot configs of the minimal ISO. A bare key
+// removes the argument with any value, a key=value argument only removes that exact argument.
+func WithRemovedKernelArgs(args ...string) MinimalISOOption {
+	return func(o *minimalISOOptions) {
+		o.removedKernelArgs = append(o.removedKernelArgs, args...)
+	}
+}
+
+func (o *minimalISOOptions) validateKernelArgEdits() error {
+	for _, arg := range append(append([]string{}, o.addedKernelArgs...), o.removedKernelArgs...) {
+		key := strings.SplitN(arg, "=", 2)[0]
+		if key == "" || strings.ContainsAny(arg, " \t\n'\"") {
+			return fmt.Errorf("invalid kernel argument %q", arg)
+		}
+		if key == o.rootFSArgKey {
+			return fmt.Errorf("kernel argument %s is set by the build and can't be edited", key)
+		}
+	}
+	return nil
+}
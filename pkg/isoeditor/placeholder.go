@@ -0,0 +1,131 @@
+package isoeditor
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// placeholderTestByte is written over the placeholder by VerifyPlaceholder
+const placeholderTestByte = 0xa5
+
+// VerifyPlaceholder checks that the custom ramdisk image placeholder of the ISO can be patched in place
+// the way the image stream does: the placeholder must have the expected size, bytes written at its offset
+// must be read back through the ISO filesystem, and nothing else in the ISO may change.
+// The placeholder is written to and restored, so the ISO must not be in use while it is checked.
+// The expected size is set with WithPlaceholderLength.
+func VerifyPlaceholder(isoPath string, opts ...MinimalISOOption) error {
+	o := newMinimalISOOptions(opts...)
+	offset, size, err := GetISOFileInfo(ramDiskImagePath, isoPath)
+	if err != nil {
+		return err
+	}
+	if size != int64(o.placeholderLength) {
+		return fmt.Errorf("placeholder %s is %d bytes, expected %d", ramDiskImagePath, size, o.placeholderLength)
+	}
+
+	sum, err := FileChecksum(isoPath, DefaultChecksumAlgorithm)
+	if err != nil {
+		return err
+	}
+
+	iso, err := os.OpenFile(isoPath, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer iso.Close()
+
+	original := make([]byte, size)
+	if _, err = iso.ReadAt(original, offset); err != nil {
+		return errors.Wrapf(err, "failed to read placeholder at offset %d", offset)
+	}
+	testContent := bytes.Repeat([]byte{placeholderTestByte}, int(size))
+	if _, err = iso.WriteAt(testContent, offset); err != nil {
+		return errors.Wrapf(err, "failed to write placeholder at offset %d", offset)
+	}
+
+	content, readErr := ReadFileFromISO(isoPath, ramDiskImagePath)
+	if _, err = iso.WriteAt(original, offset); err != nil {
+		return errors.Wrapf(err, "failed to restore placeholder at offset %d", offset)
+	}
+	if err = iso.Sync(); err != nil {
+		return err
+	}
+	if readErr != nil {
+		return readErr
+	}
+	if !bytes.Equal(content, testContent) {
+		return fmt.Errorf("content written at offset %d was not read back from placeholder %s", offset, ramDiskImagePath)
+	}
+
+	restoredSum, err := FileChecksum(isoPath, DefaultChecksumAlgorithm)
+	if err != nil {
+		return err
+	}
+	if restoredSum != sum {
+		return fmt.Errorf("patching placeholder %s changed the rest of the ISO", ramDiskImagePath)
+	}
+	return nil
+}
+
+// EmbedInitrdContent writes the content over the custom ramdisk image placeholder of the ISO in place,
+// padded with zeros to the size of the placeholder, e.g. to customize a minimal ISO without streaming it
+func EmbedInitrdContent(isoPath string, content []byte) error {
+	offset, size, err := GetISOFileInfo(ramDiskImagePath, isoPath)
+	if err != nil {
+		return err
+	}
+	if int64(len(content)) > size {
+		return fmt.Errorf("content length (%d) exceeds placeholder %s size (%d)", len(content), ramDiskImagePath, size)
+	}
+
+	iso, err := os.OpenFile(isoPath, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer iso.Close()
+
+	padded := make([]byte, size)
+	copy(padded, content)
+	if _, err = iso.WriteAt(padded, offset); err != nil {
+		return errors.Wrapf(err, "failed to write placeholder at offset %d", offset)
+	}
+	return iso.Sync()
+}
+
+// AppendToCustomInitrd appends the CPIO archive, compressed or not, after the current content of the custom
+// ramdisk image placeholder of the ISO in place. The kernel skips the zeros between concatenated archives,
+// as long as uncompressed ones start on a 4 byte boundary. The current content is taken to end at its last
+// non zero byte plus the up to 3 zeros a gzip trailer can end with, and the archive is appended at the next
+// 4 byte boundary. The combined content must fit in the placeholder.
+func AppendToCustomInitrd(isoPath string, extraCPIO []byte) error {
+	offset, size, err := GetISOFileInfo(ramDiskImagePath, isoPath)
+	if err != nil {
+		return err
+	}
+
+	iso, err := os.OpenFile(isoPath, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer iso.Close()
+
+	current := make([]byte, size)
+	if _, err = iso.ReadAt(current, offset); err != nil {
+		return errors.Wrapf(err, "failed to read placeholder at offset %d", offset)
+	}
+	var start int64
+	if end := int64(len(bytes.TrimRight(current, "\x00"))); end > 0 {
+		start = (end + 3 + 3) &^ 3
+	}
+	if start+int64(len(extraCPIO)) > size {
+		return fmt.Errorf("combined content length (%d) exceeds placeholder %s size (%d)", start+int64(len(extraCPIO)), ramDiskImagePath, size)
+	}
+
+	if _, err = iso.WriteAt(extraCPIO, offset+start); err != nil {
+		return errors.Wrapf(err, "failed to write placeholder at offset %d", offset+start)
+	}
+	return iso.Sync()
+}
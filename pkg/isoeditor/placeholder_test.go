@@ -0,0 +1,190 @@
+package isoeditor
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+
+	diskfs "github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/disk"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/iso9660"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("VerifyPlaceholder", func() {
+	var (
+		filesDir string
+		isoFile  string
+		workDir  string
+	)
+
+	BeforeEach(func() {
+		filesDir, isoFile = createTestFiles("Assisted123")
+
+		var err error
+		workDir, err = os.MkdirTemp("", "testplaceholder")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(filesDir)).To(Succeed())
+		Expect(os.Remove(isoFile)).To(Succeed())
+		Expect(os.RemoveAll(workDir)).To(Succeed())
+	})
+
+	It("succeeds for a minimal iso and leaves it unchanged", func() {
+		extractDir := filepath.Join(workDir, "extract")
+		Expect(os.Mkdir(extractDir, 0755)).To(Succeed())
+		Expect(Extract(isoFile, extractDir)).To(Succeed())
+		isoPath := filepath.Join(workDir, "minimal.iso")
+		Expect(CreateMinimalISO(extractDir, "Assisted123", testRootFSURL, "x86_64", isoPath)).To(Succeed())
+
+		sum, err := FileChecksum(isoPath, ChecksumSHA256)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(VerifyPlaceholder(isoPath)).To(Succeed())
+		restoredSum, err := FileChecksum(isoPath, ChecksumSHA256)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(restoredSum).To(Equal(sum))
+	})
+
+	It("reads back content written at the placeholder offset", func() {
+		offset, size, err := GetISOFileInfo(ramDiskImagePath, isoFile)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(size).To(Equal(int64(RamDiskPaddingLength)))
+
+		iso, err := os.OpenFile(isoFile, os.O_RDWR, 0)
+		Expect(err).ToNot(HaveOccurred())
+		content := bytes.Repeat([]byte("ramdisk!"), int(size)/8)
+		_, err = iso.WriteAt(content, offset)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(iso.Close()).To(Succeed())
+
+		read, err := ReadFileFromISO(isoFile, ramDiskImagePath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(read).To(Equal(content))
+		grubConfig, err := ReadFileFromISO(isoFile, "/EFI/redhat/grub.cfg")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(string(grubConfig)).To(Equal(testGrubConfig))
+	})
+
+	It("fails when the placeholder has the wrong size", func() {
+		Expect(os.Truncate(filepath.Join(filesDir, "images/assisted_installer_custom.img"), 4096)).To(Succeed())
+		isoPath := filepath.Join(workDir, "small.iso")
+		Expect(Create(isoPath, filesDir, "Assisted123")).To(Succeed())
+
+		err := VerifyPlaceholder(isoPath)
+		Expect(err).To(MatchError(ContainSubstring("is 4096 bytes")))
+	})
+
+	Describe("EmbedInitrdContent", func() {
+		It("replaces the placeholder content in place", func() {
+			info, err := os.Stat(isoFile)
+			Expect(err).ToNot(HaveOccurred())
+			content := []byte("custom ramdisk content")
+
+			Expect(EmbedInitrdContent(isoFile, content)).To(Succeed())
+
+			read, err := ReadFileFromISO(isoFile, ramDiskImagePath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(read).To(HaveLen(int(RamDiskPaddingLength)))
+			Expect(read[:len(content)]).To(Equal(content))
+			Expect(read[len(content):]).To(Equal(make([]byte, int(RamDiskPaddingLength)-len(content))))
+			grubConfig, err := ReadFileFromISO(isoFile, "/EFI/redhat/grub.cfg")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(grubConfig)).To(Equal(testGrubConfig))
+			newInfo, err := os.Stat(isoFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(newInfo.Size()).To(Equal(info.Size()))
+		})
+
+		It("clears the content of a previous embed", func() {
+			Expect(EmbedInitrdContent(isoFile, bytes.Repeat([]byte("a"), 100))).To(Succeed())
+			Expect(EmbedInitrdContent(isoFile, []byte("b"))).To(Succeed())
+
+			read, err := ReadFileFromISO(isoFile, ramDiskImagePath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(read[:2]).To(Equal([]byte{'b', 0}))
+		})
+
+		It("patches the placeholder of an iso with larger logical blocks", func() {
+			// ISOs with 4096 bytes logical blocks, e.g. created by older versions, can't be created by Create anymore
+			isoPath := filepath.Join(workDir, "4096.iso")
+			// the system area, a volume descriptor, the terminator and a block are the minimum size of the ISO
+			d, err := diskfs.Create(isoPath, 32*1024+2*2048+4096, diskfs.Raw, diskfs.SectorSizeDefault)
+			Expect(err).ToNot(HaveOccurred())
+			d.LogicalBlocksize = 4096
+			fs, err := d.CreateFilesystem(disk.FilesystemSpec{Partition: 0, FSType: filesystem.TypeISO9660, VolumeLabel: "Assisted123", WorkDir: filesDir})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(fs.(*iso9660.FileSystem).Finalize(iso9660.FinalizeOptions{RockRidge: true, VolumeIdentifier: "Assisted123"})).To(Succeed())
+			// diskfs writes the volume descriptors in logical blocks from block 16 instead of in the 2048 bytes
+			// sectors from sector 16 where they are read
+			iso, err := os.OpenFile(isoPath, os.O_RDWR, 0)
+			Expect(err).ToNot(HaveOccurred())
+			descriptor := make([]byte, 2048)
+			for i := int64(0); descriptor[0] != 255; i++ {
+				_, err = iso.ReadAt(descriptor, 16*4096+i*4096)
+				Expect(err).ToNot(HaveOccurred())
+				_, err = iso.WriteAt(descriptor, 16*2048+i*2048)
+				Expect(err).ToNot(HaveOccurred())
+			}
+			Expect(iso.Close()).To(Succeed())
+
+			content := []byte("custom ramdisk content")
+			Expect(EmbedInitrdContent(isoPath, content)).To(Succeed())
+
+			read, err := ReadFileFromISO(isoPath, ramDiskImagePath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(read[:len(content)]).To(Equal(content))
+			grubConfig, err := ReadFileFromISO(isoPath, "/EFI/redhat/grub.cfg")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(grubConfig)).To(Equal(testGrubConfig))
+		})
+
+		It("fails for content larger than the placeholder", func() {
+			err := EmbedInitrdContent(isoFile, make([]byte, RamDiskPaddingLength+1))
+			Expect(err).To(MatchError(ContainSubstring("exceeds placeholder")))
+		})
+	})
+
+	Describe("AppendToCustomInitrd", func() {
+		It("appends an archive after the placeholder content", func() {
+			placeholder, err := cpioArchive(map[string][]byte{"etc/placeholder": []byte("placeholder content")}, ArchiveCompressionGzip)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(EmbedInitrdContent(isoFile, placeholder.Bytes())).To(Succeed())
+
+			extra, err := cpioArchive(map[string][]byte{"config.ign": []byte("ignition content")}, ArchiveCompressionNone)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(AppendToCustomInitrd(isoFile, extra.Bytes())).To(Succeed())
+
+			read, err := ReadFileFromISO(isoFile, ramDiskImagePath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(read).To(HaveLen(int(RamDiskPaddingLength)))
+			Expect(unpackInitrd(read)).To(Equal(map[string]string{
+				"etc/placeholder": "placeholder content",
+				"config.ign":      "ignition content",
+			}))
+		})
+
+		It("writes to an empty placeholder from its start", func() {
+			extra, err := cpioArchive(map[string][]byte{"config.ign": []byte("ignition content")}, ArchiveCompressionGzip)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(AppendToCustomInitrd(isoFile, extra.Bytes())).To(Succeed())
+
+			read, err := ReadFileFromISO(isoFile, ramDiskImagePath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(read[:extra.Len()]).To(Equal(extra.Bytes()))
+		})
+
+		It("fails when the combined content is larger than the placeholder", func() {
+			Expect(EmbedInitrdContent(isoFile, bytes.Repeat([]byte("a"), int(RamDiskPaddingLength)/2))).To(Succeed())
+
+			err := AppendToCustomInitrd(isoFile, make([]byte, RamDiskPaddingLength/2))
+			Expect(err).To(MatchError(ContainSubstring("exceeds placeholder")))
+			read, err := ReadFileFromISO(isoFile, ramDiskImagePath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(read[RamDiskPaddingLength/2:]).To(Equal(make([]byte, RamDiskPaddingLength/2)))
+		})
+	})
+})
@@ -0,0 +1,93 @@
+package isoeditor
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	// offsets of the identifiers within the primary volume descriptor
+	publisherIdentifierOffset    = 318
+	dataPreparerIdentifierOffset = 446
+	provenanceIdentifierLength   = 128
+
+	// aCharacters are the characters ISO 9660 allows in the publisher and data preparer identifiers
+	aCharacters = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789 !\"%&'()*+,-./:;<=>?_"
+)
+
+// VolumeProvenance holds the publisher and data preparer identifiers of an ISO, used to record
+// where it was built, e.g. the pipeline id and commit. Identifiers are limited to 128 characters
+// among the upper case letters, digits, space and !"%&'()*+,-./:;<=>?_
+type VolumeProvenance struct {
+	Publisher    string
+	DataPreparer string
+}
+
+func (p VolumeProvenance) validate() error {
+	for name, value := range map[string]string{"publisher": p.Publisher, "data preparer": p.DataPreparer} {
+		if len(value) > provenanceIdentifierLength {
+			return fmt.Errorf("%s identifier is longer than %d characters", name, provenanceIdentifierLength)
+		}
+		for _, r := range value {
+			if !strings.ContainsRune(aCharacters, r) {
+				return fmt.Errorf("invalid character %q in %s identifier %q", r, name, value)
+			}
+		}
+	}
+	return nil
+}
+
+// SetVolumeProvenance overwrites the publisher and data preparer identifiers of the ISO, e.g. one
+// created by Create. Empty identifiers are left untouched.
+func SetVolumeProvenance(isoPath string, provenance VolumeProvenance) error {
+	if err := provenance.validate(); err != nil {
+		return err
+	}
+
+	return patchISO(isoPath, provenancePatches(provenance))
+}
+
+// provenancePatches returns the non empty identifiers padded with spaces, by their offset in the ISO
+func provenancePatches(provenance VolumeProvenance) map[int64][]byte {
+	patches := map[int64][]byte{}
+	identifiers := map[int64]string{
+		publisherIdentifierOffset:    provenance.Publisher,
+		dataPreparerIdentifierOffset: provenance.DataPreparer,
+	}
+	for offset, value := range identifiers {
+		if value == "" {
+			continue
+		}
+		padded := value + strings.Repeat(" ", provenanceIdentifierLength-len(value))
+		patches[primaryVolumeDescriptorOffset+offset] = []byte(padded)
+	}
+	return patches
+}
+
+// ReadVolumeProvenance returns the publisher and data preparer identifiers of the ISO
+func ReadVolumeProvenance(isoPath string) (VolumeProvenance, error) {
+	iso, err := os.Open(isoPath)
+	if err != nil {
+		return VolumeProvenance{}, err
+	}
+	defer iso.Close()
+
+	read := func(offset int64) (string, error) {
+		identifier := make([]byte, provenanceIdentifierLength)
+		if _, err := iso.ReadAt(identifier, primaryVolumeDescriptorOffset+offset); err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(string(bytes.TrimRight(identifier, "\x00"))), nil
+	}
+
+	var provenance VolumeProvenance
+	if provenance.Publisher, err = read(publisherIdentifierOffset); err != nil {
+		return VolumeProvenance{}, err
+	}
+	if provenance.DataPreparer, err = read(dataPreparerIdentifierOffset); err != nil {
+		return VolumeProvenance{}, err
+	}
+	return provenance, nil
+}
@@ -0,0 +1,65 @@
+package isoeditor
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("VolumeProvenance", func() {
+	var (
+		filesDir   string
+		isoFile    string
+		extractDir string
+		workDir    string
+	)
+
+	BeforeEach(func() {
+		filesDir, isoFile = createTestFiles("Assisted123")
+
+		var err error
+		workDir, err = os.MkdirTemp("", "testprovenance")
+		Expect(err).NotTo(HaveOccurred())
+		extractDir = filepath.Join(workDir, "extract")
+		Expect(os.Mkdir(extractDir, 0755)).To(Succeed())
+		Expect(Extract(isoFile, extractDir)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(filesDir)).To(Succeed())
+		Expect(os.Remove(isoFile)).To(Succeed())
+		Expect(os.RemoveAll(workDir)).To(Succeed())
+	})
+
+	It("sets the provenance of the minimal iso", func() {
+		provenance := VolumeProvenance{
+			Publisher:    "PIPELINE:1234",
+			DataPreparer: "COMMIT:0123456789ABCDEF",
+		}
+		isoPath := filepath.Join(workDir, "minimal.iso")
+		Expect(CreateMinimalISO(extractDir, "Assisted123", testRootFSURL, "x86_64", isoPath, WithVolumeProvenance(provenance))).To(Succeed())
+
+		read, err := ReadVolumeProvenance(isoPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(read).To(Equal(provenance))
+
+		volumeID, err := VolumeIdentifier(isoPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(volumeID).To(Equal("Assisted123"))
+	})
+
+	It("rejects invalid identifiers", func() {
+		isoPath := filepath.Join(workDir, "minimal.iso")
+		for _, provenance := range []VolumeProvenance{
+			{Publisher: "pipeline=1234"},
+			{DataPreparer: strings.Repeat("A", 129)},
+		} {
+			err := CreateMinimalISO(extractDir, "Assisted123", testRootFSURL, "x86_64", isoPath, WithVolumeProvenance(provenance))
+			Expect(err).To(HaveOccurred())
+			Expect(isoPath).ToNot(BeAnExistingFile())
+		}
+	})
+})
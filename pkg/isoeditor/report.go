@@ -0,0 +1,94 @@
+package isoeditor
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// BuildReport summarizes a minimal ISO build, see WithBuildReport
+type BuildReport struct {
+	VolumeID  string `json:"volumeID"`
+	RootFSURL string `json:"rootFSURL,omitempty"`
+	Arch      string `json:"arch"`
+	// Path and Size are those of the minimal ISO
+	Path string `json:"path"`
+	Size int64  `json:"size"`
+	// Checksum is set with WithChecksum or WithChecksumSidecar
+	Checksum          string            `json:"checksum,omitempty"`
+	ChecksumAlgorithm ChecksumAlgorithm `json:"checksumAlgorithm,omitempty"`
+	// GrubConfig is the path of the edited grub config within the ISO
+	GrubConfig string `json:"grubConfig,omitempty"`
+	// Isolinux is set when the isolinux config was edited
+	Isolinux bool           `json:"isolinux"`
+	Stages   []StageTiming  `json:"stages"`
+	Warnings []BuildWarning `json:"warnings,omitempty"`
+}
+
+// BuildResult describes a completed minimal ISO build, see WithBuildResult
+type BuildResult struct {
+	// Path is the path of the minimal ISO
+	Path string
+	// GrubConfig is the path of the edited grub config within the ISO
+	GrubConfig string
+	// Isolinux is set when the isolinux config was edited
+	Isolinux bool
+	// Checksum, ChecksumAlgorithm and Size are set with WithChecksum or WithChecksumSidecar
+	Checksum          string
+	ChecksumAlgorithm ChecksumAlgorithm
+	Size              int64
+}
+
+// WithBuildResult makes the build fill the result once the minimal ISO is built. Unlike the completed
+// build event it is never dropped. CreateMinimalISOs returns the result of each minimal ISO instead.
+func WithBuildResult(result *BuildResult) MinimalISOOption {
+	return func(o *minimalISOOptions) {
+		o.result = result
+	}
+}
+
+// StageTiming is the time a build stage took
+type StageTiming struct {
+	Stage   string  `json:"stage"`
+	Seconds float64 `json:"seconds"`
+}
+
+// BuildReportPath returns the path of the build report written next to the given minimal ISO
+func BuildReportPath(isoPath string) string {
+	return fmt.Sprintf("%s.report.json", isoPath)
+}
+
+func (o *minimalISOOptions) recordStage(stage string, duration time.Duration) {
+	if o.buildReport {
+		o.stageTimings = append(o.stageTimings, StageTiming{Stage: stage, Seconds: duration.Seconds()})
+	}
+}
+
+// writeBuildReport writes the report of the build next to the minimal ISO
+func writeBuildReport(report *BuildReport) error {
+	info, err := os.Stat(report.Path)
+	if err != nil {
+		return err
+	}
+	report.Size = info.Size()
+
+	content, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(BuildReportPath(report.Path), append(content, '\n'), 0600)
+}
+
+// ReadBuildReport reads a build report written by a build with WithBuildReport
+func ReadBuildReport(path string) (*BuildReport, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	report := &BuildReport{}
+	if err := json.Unmarshal(content, report); err != nil {
+		return nil, fmt.Errorf("invalid build report %s: %w", path, err)
+	}
+	return report, nil
+}
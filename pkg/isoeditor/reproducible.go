@@ -0,0 +1,246 @@
+package isoeditor
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	primaryVolumeDescriptorOffset = 32768
+	// offsets of the volume dates within the primary volume descriptor
+	volumeCreationDateOffset     = 813
+	volumeModificationDateOffset = 830
+	volumeExpirationDateOffset   = 847
+	volumeEffectiveDateOffset    = 864
+	// offsets of the logical block size and the root directory record within the primary volume descriptor
+	logicalBlockSizeOffset    = 128
+	rootDirectoryRecordOffset = 156
+	rootDirectoryRecordLength = 34
+)
+
+// flag of the directory records of directories
+const directoryRecordDirectoryFlag = 0x02
+
+// ReproducibleCreateMinimalISO creates the minimal ISO like CreateMinimalISO, setting every
+// timestamp stored in the ISO to the given epoch. The permissions of the extracted files are
+// normalized and the Rock Ridge owners are set to root, so identical inputs produce byte identical
+// ISOs whatever the host, user, umask or time zone building them.
+func ReproducibleCreateMinimalISO(extractDir, volumeID, rootFSURL, arch, minimalISOPath string, epoch time.Time, opts ...MinimalISOOption) error {
+	opts = append(opts, WithSourceDateEpoch(epoch))
+	return CreateMinimalISO(extractDir, volumeID, rootFSURL, arch, minimalISOPath, opts...)
+}
+
+// normalizeTree sets the access and modification times of every file in the directory tree and
+// its permissions to 0755 for directories and executables, 0644 for the other files. Symlinks are
+// left as is, their times are only set in the ISO by normalizeISO.
+func normalizeTree(dir string, t time.Time) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		var perm fs.FileMode = 0o644
+		if d.IsDir() || info.Mode()&0o111 != 0 {
+			perm = 0o755
+		}
+		if err := os.Chmod(path, perm); err != nil {
+			return err
+		}
+		return os.Chtimes(path, t, t)
+	})
+}
+
+// normalizeISO sets the attributes diskfs copies from the host to fixed values: the dates of the
+// directory records and the Rock Ridge timestamps, which hold the change time of the files and
+// are recorded in the local time zone, are set to the given time and the Rock Ridge owners to root
+func normalizeISO(isoPath string, t time.Time) error {
+	iso, err := os.OpenFile(isoPath, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer iso.Close()
+
+	pvd := make([]byte, rootDirectoryRecordOffset+rootDirectoryRecordLength)
+	if _, err = iso.ReadAt(pvd, primaryVolumeDescriptorOffset); err != nil {
+		return err
+	}
+	n := isoNormalizer{
+		iso:        iso,
+		blockSize:  int64(binary.LittleEndian.Uint16(pvd[logicalBlockSizeOffset:])),
+		recordDate: recordDate(t),
+		stampDate:  volumeDate(t),
+		visited:    map[uint32]bool{},
+	}
+	if n.blockSize == 0 {
+		return fmt.Errorf("invalid logical block size 0 in %s", isoPath)
+	}
+	// the root directory record of the volume descriptor has no system use area
+	root := pvd[rootDirectoryRecordOffset:]
+	if _, err = iso.WriteAt(n.recordDate, primaryVolumeDescriptorOffset+rootDirectoryRecordOffset+18); err != nil {
+		return err
+	}
+	if err = n.normalizeDirectory(binary.LittleEndian.Uint32(root[2:]), binary.LittleEndian.Uint32(root[10:])); err != nil {
+		return fmt.Errorf("failed to normalize %s: %w", isoPath, err)
+	}
+	return iso.Sync()
+}
+
+type isoNormalizer struct {
+	iso       *os.File
+	blockSize int64
+	// recordDate is the date of the directory records, stampDate the long form of the Rock Ridge timestamps
+	recordDate []byte
+	stampDate  []byte
+	// visited holds the location of the directories normalized so far
+	visited map[uint32]bool
+}
+
+// normalizeDirectory normalizes the records of the directory at the block location, then its subdirectories
+func (n *isoNormalizer) normalizeDirectory(location, size uint32) error {
+	if n.visited[location] {
+		return nil
+	}
+	n.visited[location] = true
+
+	dir := make([]byte, size)
+	if _, err := n.iso.ReadAt(dir, int64(location)*n.blockSize); err != nil {
+		return err
+	}
+	type extent struct{ location, size uint32 }
+	var subdirs []extent
+	for offset := int64(0); offset < int64(len(dir)); {
+		length := int64(dir[offset])
+		// records don't span blocks, the rest of a block is zero filled
+		if length == 0 {
+			offset = (offset/n.blockSize + 1) * n.blockSize
+			continue
+		}
+		if length < 34 || offset+length > int64(len(dir)) {
+			return fmt.Errorf("invalid directory record at %d in the directory at block %d", offset, location)
+		}
+		record := dir[offset : offset+length]
+		copy(record[18:25], n.recordDate)
+
+		nameLength := int(record[32])
+		systemUse := 33 + nameLength
+		// the name is padded to an even length
+		if nameLength%2 == 0 {
+			systemUse++
+		}
+		if systemUse < len(record) {
+			if err := n.normalizeSystemUse(record[systemUse:]); err != nil {
+				return err
+			}
+		}
+		// the first records are the directory itself and its parent, named 0 and 1
+		isSelfOrParent := nameLength == 1 && record[33] <= 1
+		if record[25]&directoryRecordDirectoryFlag != 0 && !isSelfOrParent {
+			subdirs = append(subdirs, extent{binary.LittleEndian.Uint32(record[2:]), binary.LittleEndian.Uint32(record[10:])})
+		}
+		offset += length
+	}
+	if _, err := n.iso.WriteAt(dir, int64(location)*n.blockSize); err != nil {
+		return err
+	}
+
+	for _, subdir := range subdirs {
+		if err := n.normalizeDirectory(subdir.location, subdir.size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// normalizeSystemUse normalizes the SUSP entries of a directory record or a continuation area
+func (n *isoNormalizer) normalizeSystemUse(entries []byte) error {
+	for len(entries) >= 4 {
+		length := int(entries[2])
+		if length < 4 || length > len(entries) {
+			// padding
+			return nil
+		}
+		entry := entries[:length]
+		switch string(entry[:2]) {
+		case "PX":
+			// mode, link count, uid and gid, each stored both little and big endian
+			if length >= 36 {
+				copy(entry[20:36], make([]byte, 16))
+			}
+		case "TF":
+			stampLength := 7
+			// long form flag
+			if entry[4]&0x80 != 0 {
+				stampLength = 17
+			}
+			for i := 5; i+stampLength <= length; i += stampLength {
+				if stampLength == 7 {
+					copy(entry[i:], n.recordDate)
+				} else {
+					copy(entry[i:], n.stampDate)
+				}
+			}
+		case "CE":
+			if length < 28 {
+				return fmt.Errorf("invalid continuation entry of %d bytes", length)
+			}
+			if err := n.normalizeContinuation(binary.LittleEndian.Uint32(entry[4:]), binary.LittleEndian.Uint32(entry[12:]),
+				binary.LittleEndian.Uint32(entry[20:])); err != nil {
+				return err
+			}
+		case "ST":
+			return nil
+		}
+		entries = entries[length:]
+	}
+	return nil
+}
+
+// normalizeContinuation normalizes the SUSP entries continued in the area at the block location
+func (n *isoNormalizer) normalizeContinuation(location, offset, length uint32) error {
+	area := make([]byte, length)
+	position := int64(location)*n.blockSize + int64(offset)
+	if _, err := n.iso.ReadAt(area, position); err != nil {
+		return err
+	}
+	if err := n.normalizeSystemUse(area); err != nil {
+		return err
+	}
+	_, err := n.iso.WriteAt(area, position)
+	return err
+}
+
+// volumeDatePatches returns the dates of the primary volume descriptor set to the given time, by their
+// offset in the ISO. The expiration date is left unspecified.
+func volumeDatePatches(t time.Time) map[int64][]byte {
+	date := volumeDate(t)
+	return map[int64][]byte{
+		primaryVolumeDescriptorOffset + volumeCreationDateOffset:     date,
+		primaryVolumeDescriptorOffset + volumeModificationDateOffset: date,
+		primaryVolumeDescriptorOffset + volumeExpirationDateOffset:   append([]byte("0000000000000000"), 0),
+		primaryVolumeDescriptorOffset + volumeEffectiveDateOffset:    date,
+	}
+}
+
+// recordDate formats the time as an ISO 9660 directory record date in UTC
+func recordDate(t time.Time) []byte {
+	t = t.UTC()
+	return []byte{byte(t.Year() - 1900), byte(t.Month()), byte(t.Day()), byte(t.Hour()), byte(t.Minute()), byte(t.Second()), 0}
+}
+
+// volumeDate formats the time as an ISO 9660 volume descriptor date in UTC
+func volumeDate(t time.Time) []byte {
+	t = t.UTC()
+	date := fmt.Sprintf("%s%02d", t.Format("20060102150405"), t.Nanosecond()/int(10*time.Millisecond))
+	// the last byte is the offset from GMT in 15 minute intervals
+	return append([]byte(date), 0)
+}
@@ -0,0 +1,117 @@
+package isoeditor
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("ReproducibleCreateMinimalISO", func() {
+	var (
+		filesDir string
+		isoFile  string
+		epoch    = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	)
+
+	BeforeEach(func() {
+		filesDir, isoFile = createTestFiles("Assisted123")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(filesDir)).To(Succeed())
+		Expect(os.Remove(isoFile)).To(Succeed())
+	})
+
+	// build extracts the full iso to a new temporary directory and builds the minimal iso from it in
+	// the returned work dir, calling prepare with the extract dir before the build
+	build := func(prepare ...func(extractDir string)) (string, string) {
+		workDir, err := os.MkdirTemp("", "testreproducible")
+		Expect(err).ToNot(HaveOccurred())
+
+		extractDir := filepath.Join(workDir, "extract")
+		Expect(os.Mkdir(extractDir, 0755)).To(Succeed())
+		Expect(Extract(isoFile, extractDir)).To(Succeed())
+		for _, p := range prepare {
+			p(extractDir)
+		}
+
+		isoPath := filepath.Join(workDir, "minimal.iso")
+		Expect(ReproducibleCreateMinimalISO(extractDir, "Assisted123", testRootFSURL, "x86_64", isoPath, epoch)).To(Succeed())
+		return workDir, isoPath
+	}
+
+	// checksum builds the minimal iso in a new temporary directory and returns its checksum
+	checksum := func() string {
+		workDir, isoPath := build()
+		defer os.RemoveAll(workDir)
+		sum, err := FileChecksum(isoPath, ChecksumSHA256)
+		Expect(err).ToNot(HaveOccurred())
+		return sum
+	}
+
+	It("creates identical isos from different extract dirs of the same input", func() {
+		Expect(checksum()).To(Equal(checksum()))
+	})
+
+	It("creates identical isos whatever the permissions, owners and time zone of the host", func() {
+		first := checksum()
+
+		// the files are extracted with other permissions and dated in another time zone
+		oldMask := syscall.Umask(0o077)
+		defer syscall.Umask(oldMask)
+		oldLocal := time.Local
+		time.Local = time.FixedZone("UTC+5", 5*60*60)
+		defer func() { time.Local = oldLocal }()
+
+		Expect(checksum()).To(Equal(first))
+	})
+
+	It("sets the volume dates to the epoch", func() {
+		workDir, isoPath := build()
+		defer os.RemoveAll(workDir)
+
+		iso, err := os.Open(isoPath)
+		Expect(err).ToNot(HaveOccurred())
+		defer iso.Close()
+		date := make([]byte, 17)
+		_, err = iso.ReadAt(date, primaryVolumeDescriptorOffset+volumeCreationDateOffset)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(date).To(Equal(append([]byte("2024010203040500"), 0)))
+	})
+
+	It("records root as the owner of every file", func() {
+		workDir, isoPath := build(func(extractDir string) {
+			// the files are already owned by another user when the tests don't run as root
+			if os.Geteuid() != 0 {
+				return
+			}
+			Expect(filepath.Walk(extractDir, func(path string, _ os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				return os.Lchown(path, 1000, 1000)
+			})).To(Succeed())
+		})
+		defer os.RemoveAll(workDir)
+
+		content, err := os.ReadFile(isoPath)
+		Expect(err).ToNot(HaveOccurred())
+		entries := 0
+		for i := bytes.Index(content, []byte("PX\x2c\x01")); i >= 0; {
+			// the uid and gid follow the mode and the link count
+			Expect(content[i+20 : i+36]).To(Equal(make([]byte, 16)))
+			entries++
+			next := bytes.Index(content[i+1:], []byte("PX\x2c\x01"))
+			if next < 0 {
+				break
+			}
+			i += 1 + next
+		}
+		Expect(entries).ToNot(BeZero())
+	})
+})
@@ -1,101 +1,638 @@
 package isoeditor
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
 
+	diskfs "github.com/diskfs/go-diskfs"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"github.com/thoas/go-funk"
 )
 
 const (
 	RamDiskPaddingLength = uint64(1024 * 1024) // 1MB
 	ramDiskImagePath     = "/images/assisted_installer_custom.img"
+	rootFSPathInISO      = "images/pxeboot/rootfs.img"
 )
 
+var gzipMagic = []byte{0x1f, 0x8b}
+
 //go:generate mockgen -package=isoeditor -destination=mock_editor.go . Editor
 type Editor interface {
-	CreateMinimalISOTemplate(fullISOPath, rootFSURL, arch, minimalISOPath string) error
+	CreateMinimalISOTemplate(ctx context.Context, fullISOPath, rootFSURL, arch, minimalISOPath string) error
+	CreateMinimalISOTemplateFromReader(ctx context.Context, r io.ReaderAt, size int64, rootFSURL, arch, minimalISOPath string) error
+	// Close removes the temporary directories left by the builds of the editor
+	Close() error
 }
 
 type rhcosEditor struct {
-	workDir string
+	// tempDir is where the temporary directories of the builds are created
+	tempDir string
+	opts    []MinimalISOOption
+
+	// tempDirs are the temporary directories of the builds not removed yet
+	tempDirsLock sync.Mutex
+	tempDirs     map[string]struct{}
 }
 
-func NewEditor(dataDir string) Editor {
-	return &rhcosEditor{workDir: dataDir}
+func NewEditor(dataDir string, opts ...MinimalISOOption) Editor {
+	o := newMinimalISOOptions(opts...)
+	// register the build metrics once instead of on each build
+	if o.metrics != nil {
+		opts = append(append([]MinimalISOOption{}, opts...), withBuildMetrics(o.metrics))
+	}
+	tempDir := dataDir
+	if o.tempDir != "" {
+		tempDir = o.tempDir
+	}
+	return &rhcosEditor{tempDir: tempDir, opts: opts, tempDirs: map[string]struct{}{}}
 }
 
-// CreateMinimalISO Creates the minimal iso by removing the rootfs and adding the url
-func CreateMinimalISO(extractDir, volumeID, rootFSURL, arch, minimalISOPath string) error {
-	if err := os.Remove(filepath.Join(extractDir, "images/pxeboot/rootfs.img")); err != nil {
-		return err
+func (e *rhcosEditor) mkdirTemp() (string, error) {
+	dir, err := os.MkdirTemp(e.tempDir, "isoutil")
+	if err != nil {
+		return "", err
 	}
+	e.tempDirsLock.Lock()
+	defer e.tempDirsLock.Unlock()
+	e.tempDirs[dir] = struct{}{}
+	return dir, nil
+}
 
-	if err := embedInitrdPlaceholders(extractDir); err != nil {
-		log.WithError(err).Warnf("Failed to embed initrd placeholders")
+func (e *rhcosEditor) removeTempDir(dir string) error {
+	if err := os.RemoveAll(dir); err != nil {
 		return err
 	}
+	e.tempDirsLock.Lock()
+	defer e.tempDirsLock.Unlock()
+	delete(e.tempDirs, dir)
+	return nil
+}
+
+// Close removes the temporary directories the builds failed to remove, and the ones of builds still
+// running, so it must only be called once the editor isn't used anymore
+func (e *rhcosEditor) Close() error {
+	e.tempDirsLock.Lock()
+	dirs := make([]string, 0, len(e.tempDirs))
+	for dir := range e.tempDirs {
+		dirs = append(dirs, dir)
+	}
+	e.tempDirsLock.Unlock()
+
+	var failed []string
+	for _, dir := range dirs {
+		if err := e.removeTempDir(dir); err != nil {
+			failed = append(failed, dir)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to remove temporary directories %v", failed)
+	}
+	return nil
+}
+
+// CreateMinimalISO Creates the minimal iso by removing the rootfs and adding the url.
+// The ISO is built in place from extractDir, which is removed once the ISO is written; use
+// CreateMinimalISOFromDir to keep it. Only the boot config edits of different extract dirs can run
+// concurrently: writing the ISO changes the working directory of the process, so builds must not overlap.
+func CreateMinimalISO(extractDir, volumeID, rootFSURL, arch, minimalISOPath string, opts ...MinimalISOOption) error {
+	o := newMinimalISOOptions(opts...)
+	defer o.startBuild()()
+	return createMinimalISO(extractDir, volumeID, rootFSURL, arch, minimalISOPath, o)
+}
 
-	if err := fixGrubConfig(rootFSURL, extractDir); err != nil {
-		log.WithError(err).Warnf("Failed to edit grub config")
+// CreateMinimalISOFromDir creates the minimal iso from a full ISO the caller already extracted to extractDir,
+// skipping the extraction done by CreateMinimalISOTemplate. The volumeID is the one of the full ISO and
+// the arch is detected from the tree when empty. The caller owns the tree, which is left as is so it can
+// be reused for other minimal ISOs: the ISO is built from a clone of it in a temporary directory under
+// os.TempDir, or the one set with WithTempDir, where only the boot configs and the custom ramdisk image
+// placeholder are copied when the files can be hard linked.
+func CreateMinimalISOFromDir(extractDir, volumeID, rootFSURL, arch, minimalISOPath string, opts ...MinimalISOOption) error {
+	o := newMinimalISOOptions(opts...)
+	defer o.startBuild()()
+	if err := o.validateRootFSURL(rootFSURL); err != nil {
 		return err
 	}
 
-	// ignore isolinux.cfg for ppc64le because it doesn't exist
-	if arch != "ppc64le" {
-		if err := fixIsolinuxConfig(rootFSURL, extractDir); err != nil {
-			log.WithError(err).Warnf("Failed to edit isolinux config")
+	if arch == "" {
+		err := o.runStage(StageDetectArch, func() error {
+			var detectErr error
+			arch, detectErr = detectArch(extractDir)
+			return detectErr
+		})
+		if err != nil {
 			return err
 		}
 	}
 
-	if err := Create(minimalISOPath, extractDir, volumeID); err != nil {
+	return createMinimalISOFromClone(extractDir, volumeID, rootFSURL, arch, minimalISOPath, o)
+}
+
+// CreateMinimalISOReader makes the same edits as CreateMinimalISO but returns a reader of the minimal ISO
+// instead of writing it to a caller provided path. Like with CreateMinimalISOFromDir, extractDir is left
+// as is and the ISO is built from a clone of it. The clone and the ISO are written to temporary
+// directories under os.TempDir, or the one set with WithTempDir, which needs about the size of the
+// minimal ISO of free space, or of the whole extracted tree when it's on another filesystem than
+// extractDir, and the ISO isn't held in memory. The clone is removed once the ISO is built, the
+// temporary directory of the ISO, and the files post-processors or sidecar options wrote to it, once
+// the reader is closed.
+func CreateMinimalISOReader(extractDir, volumeID, rootFSURL, arch string, opts ...MinimalISOOption) (io.ReadCloser, error) {
+	o := newMinimalISOOptions(opts...)
+	defer o.startBuild()()
+	tempDir, err := os.MkdirTemp(o.tempDir, "minimal-iso")
+	if err != nil {
+		return nil, err
+	}
+	isoPath := filepath.Join(tempDir, "minimal.iso")
+	if err = createMinimalISOFromClone(extractDir, volumeID, rootFSURL, arch, isoPath, o); err == nil {
+		var f *os.File
+		if f, err = os.Open(isoPath); err == nil {
+			return &tempISOReader{File: f, dir: tempDir}, nil
+		}
+	}
+	if removeErr := os.RemoveAll(tempDir); removeErr != nil {
+		o.pathLogger(tempDir).WithError(removeErr).Errorf("Failed to remove %s", tempDir)
+	}
+	return nil, err
+}
+
+// tempISOReader reads an ISO built to a temporary directory, removing the directory once closed
+type tempISOReader struct {
+	*os.File
+	dir string
+}
+
+func (r *tempISOReader) Close() error {
+	closeErr := r.File.Close()
+	if err := os.RemoveAll(r.dir); err != nil {
 		return err
 	}
-	return nil
+	return closeErr
 }
 
-// CreateMinimalISOTemplate Creates the template minimal iso by removing the rootfs and adding the url
-func (e *rhcosEditor) CreateMinimalISOTemplate(fullISOPath, rootFSURL, arch, minimalISOPath string) error {
-	extractDir, err := os.MkdirTemp(e.workDir, "isoutil")
+func createMinimalISO(extractDir, volumeID, rootFSURL, arch, minimalISOPath string, o *minimalISOOptions) error {
+	o.arch = arch
+	if err := o.validateRootFSURL(rootFSURL); err != nil {
+		return err
+	}
+	if strings.ContainsAny(o.rootFSDeviceLabel, " \t\n'") {
+		return fmt.Errorf("invalid rootfs device label %q", o.rootFSDeviceLabel)
+	}
+	if o.rootFSArgKey == "" || strings.ContainsAny(o.rootFSArgKey, " \t\n'=") {
+		return fmt.Errorf("invalid rootfs argument key %q", o.rootFSArgKey)
+	}
+	if o.embedRootFS && (o.rootFSDeviceLabel != "" || o.volumeID != "") {
+		// the embedded rootfs is found through the volume identifier of the source ISO
+		return fmt.Errorf("embedded rootfs can't be used with a rootfs device label or a volume identifier override")
+	}
+	if o.ignitionPlatformID == "" || strings.ContainsAny(o.ignitionPlatformID, " \t\n'=") {
+		return fmt.Errorf("invalid ignition platform id %q", o.ignitionPlatformID)
+	}
+	if err := o.validateKernelArgEdits(); err != nil {
+		return err
+	}
+	if err := o.validateExtraInitrdImages(); err != nil {
+		return err
+	}
+	if _, err := o.checksumAlgorithm.newHash(); err != nil {
+		return err
+	}
+	if o.copyNetworkArg != "" && !funk.ContainsString(copyNetworkArgs, o.copyNetworkArg) {
+		return fmt.Errorf("unsupported copy network argument %q, expected one of %v", o.copyNetworkArg, copyNetworkArgs)
+	}
+	if o.provenance != nil {
+		if err := o.provenance.validate(); err != nil {
+			return err
+		}
+	}
+	placeholder, err := o.placeholderContent()
 	if err != nil {
 		return err
 	}
+	volumeID, volumeIDWarnings, err := o.resolveVolumeID(volumeID)
+	if err != nil {
+		return err
+	}
+
+	// without any boot config the minimal ISO couldn't find its rootfs
+	if err = checkBootConfigs(extractDir); err != nil {
+		return err
+	}
+	for _, image := range o.extraInitrdImages {
+		if _, err = os.Stat(filepath.Join(extractDir, image)); err != nil {
+			return fmt.Errorf("extra initrd image %s not found: %w", image, err)
+		}
+	}
+	if o.embedRootFS {
+		if _, err = os.Stat(filepath.Join(extractDir, rootFSPathInISO)); err != nil {
+			return fmt.Errorf("rootfs to embed not found: %w", err)
+		}
+	}
+
+	var configsBefore map[string][]byte
+	if o.dryRun != nil {
+		if configsBefore, err = readBootConfigs(extractDir); err != nil {
+			return err
+		}
+	}
 
-	if err = Extract(fullISOPath, extractDir); err != nil {
+	err = o.runStage(StageRemoveRootFS, func() error {
+		if o.dryRun != nil || o.embedRootFS {
+			return nil
+		}
+		return removeRootFS(filepath.Join(extractDir, rootFSPathInISO))
+	})
+	if err != nil {
 		return err
 	}
 
-	volumeID, err := VolumeIdentifier(fullISOPath)
+	err = o.runStage(StageEmbedPlaceholders, func() error {
+		if o.dryRun != nil {
+			return nil
+		}
+		return embedInitrdPlaceholders(extractDir, placeholder, o.placeholderLength, o.stageLogger(StageEmbedPlaceholders, extractDir))
+	})
 	if err != nil {
+		o.warn(StageEmbedPlaceholders, "Failed to embed initrd placeholders")
 		return err
 	}
 
-	err = CreateMinimalISO(extractDir, volumeID, rootFSURL, arch, minimalISOPath)
+	var grubConfig string
+	warnings := o.warnAll(StageVolumeIdentifier, volumeIDWarnings)
+	for _, warning := range warnings {
+		o.stageLogger(StageVolumeIdentifier, extractDir).Warn(warning.Message)
+	}
+	// s390x ISOs don't use grub, their kernel arguments are in parm files
+	if arch == "s390x" {
+		err = o.runStage(StagePrmConfig, func() error {
+			return fixPrmConfigs(rootFSURL, extractDir, o)
+		})
+		if err != nil {
+			o.stageLogger(StagePrmConfig, extractDir).WithError(err).Warnf("Failed to edit parm files")
+			o.warn(StagePrmConfig, "Failed to edit parm files")
+			return err
+		}
+	} else {
+		err = o.runStage(StageGrubConfig, func() error {
+			grubWarnings, err := fixGrubConfig(rootFSURL, extractDir, o)
+			if err != nil {
+				return err
+			}
+			warnings = append(warnings, o.warnAll(StageGrubConfig, grubWarnings)...)
+			grubPath, err := findGrubConfig(extractDir)
+			if err != nil {
+				return err
+			}
+			if grubConfig, err = filepath.Rel(extractDir, grubPath); err != nil {
+				return err
+			}
+			if timeout, ok := o.bootTimeouts[arch]; ok {
+				return setGrubTimeout(extractDir, timeout)
+			}
+			return nil
+		})
+		if err != nil {
+			o.stageLogger(StageGrubConfig, extractDir).WithError(err).Warnf("Failed to edit grub config")
+			o.warn(StageGrubConfig, "Failed to edit grub config")
+			return err
+		}
+	}
+
+	isolinux := hasIsolinux(arch)
+	if isolinux {
+		err = o.runStage(StageIsolinuxConfig, func() error {
+			isolinuxWarnings, err := fixIsolinuxConfig(rootFSURL, extractDir, o)
+			if err != nil {
+				return err
+			}
+			warnings = append(warnings, o.warnAll(StageIsolinuxConfig, isolinuxWarnings)...)
+			for _, warning := range isolinuxWarnings {
+				if warning.Kind == BuildWarningMissingConfig {
+					isolinux = false
+					return nil
+				}
+			}
+			if timeout, ok := o.bootTimeouts[arch]; ok {
+				return setIsolinuxTimeout(extractDir, timeout)
+			}
+			return nil
+		})
+		if err != nil {
+			o.stageLogger(StageIsolinuxConfig, extractDir).WithError(err).Warnf("Failed to edit isolinux config")
+			o.warn(StageIsolinuxConfig, "Failed to edit isolinux config")
+			return err
+		}
+	}
+
+	if o.dryRun != nil {
+		configsAfter, err := readBootConfigs(extractDir)
+		if err != nil {
+			return err
+		}
+		*o.dryRun = DryRunReport{
+			VolumeID:     volumeID,
+			Arch:         arch,
+			InitrdImages: o.initrdImages(),
+			Warnings:     warnings,
+		}
+		return o.dryRun.setEdits(extractDir, configsBefore, configsAfter)
+	}
+
+	var output *isoOutput
+	err = o.runStage(StageCreate, func() error {
+		// create the ISO next to its final path so the rename is atomic, even when the work dir is on another filesystem
+		tmpPath := tempISOPath(minimalISOPath)
+		if err := os.Remove(tmpPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		var err error
+		output, err = createISO(tmpPath, extractDir, volumeID, o)
+		// diskfs ignores the errors of the last writes of the ISO, e.g. of its volume descriptors, so the
+		// ISO of a build done while they were written is incomplete even when no error is returned
+		if ctxErr := o.ctx.Err(); ctxErr != nil {
+			err = ctxErr
+		}
+		if err != nil {
+			if removeErr := os.Remove(tmpPath); removeErr != nil && !os.IsNotExist(removeErr) {
+				o.pathLogger(tmpPath).WithError(removeErr).Errorf("Failed to remove %s", tmpPath)
+			}
+			return err
+		}
+		return moveFile(tmpPath, minimalISOPath, os.Rename)
+	})
 	if err != nil {
 		return err
 	}
 
+	var checksum string
+	var size int64
+	if o.checksum || o.checksumSidecar {
+		err = o.runStage(StageChecksum, func() error {
+			var sumErr error
+			checksum, size, sumErr = output.checksum(minimalISOPath)
+			if sumErr != nil || !o.checksumSidecar {
+				return sumErr
+			}
+			return writeChecksumSidecar(minimalISOPath, o.checksumAlgorithm, checksum)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if o.buildReport {
+		report := &BuildReport{
+			VolumeID:   volumeID,
+			Arch:       arch,
+			Path:       minimalISOPath,
+			GrubConfig: grubConfig,
+			Isolinux:   isolinux,
+			Stages:     o.stageTimings,
+			Warnings:   warnings,
+		}
+		if checksum != "" {
+			report.Checksum = checksum
+			report.ChecksumAlgorithm = o.checksumAlgorithm
+		}
+		if o.rootFSDeviceLabel == "" && !o.embedRootFS {
+			report.RootFSURL = rootFSURL
+		}
+		err = o.runStage(StageReport, func() error {
+			return writeBuildReport(report)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(o.postProcessors) > 0 {
+		err = o.runStage(StagePostProcess, func() error {
+			for i, postProcess := range o.postProcessors {
+				if err := postProcess(minimalISOPath); err != nil {
+					return fmt.Errorf("post-processor %d failed for %s: %w", i, minimalISOPath, err)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if o.result != nil {
+		*o.result = BuildResult{Path: minimalISOPath, GrubConfig: grubConfig, Isolinux: isolinux, Checksum: checksum, Size: size}
+		if checksum != "" {
+			o.result.ChecksumAlgorithm = o.checksumAlgorithm
+		}
+	}
+	o.reportProgress("", nil)
+	o.emit(BuildEvent{Type: BuildEventCompleted, Path: minimalISOPath, GrubConfig: grubConfig, Isolinux: isolinux, Warnings: warnings, Checksum: checksum, Size: size})
 	return nil
 }
 
-func embedInitrdPlaceholders(extractDir string) error {
-	f, err := os.Create(filepath.Join(extractDir, ramDiskImagePath))
+// createISO creates the ISO, the returned output holds its checksum when the build needs it
+func createISO(isoPath, extractDir, volumeID string, o *minimalISOOptions) (*isoOutput, error) {
+	output := &isoOutput{ctx: o.ctx, patches: map[int64][]byte{}, algorithm: o.checksumAlgorithm}
+	if o.sourceDateEpoch != nil {
+		if err := normalizeTree(extractDir, *o.sourceDateEpoch); err != nil {
+			return nil, err
+		}
+		for offset, patch := range volumeDatePatches(*o.sourceDateEpoch) {
+			output.patches[offset] = patch
+		}
+	}
+	if o.provenance != nil {
+		for offset, patch := range provenancePatches(*o.provenance) {
+			output.patches[offset] = patch
+		}
+	}
+	if o.checksum || o.checksumSidecar {
+		var err error
+		if output.hash, err = o.checksumAlgorithm.newHash(); err != nil {
+			return nil, err
+		}
+	}
+	if err := create(isoPath, extractDir, volumeID, o.blockSize, o.bootEntries, output); err != nil {
+		return nil, err
+	}
+	if o.sourceDateEpoch != nil {
+		if err := normalizeISO(isoPath, *o.sourceDateEpoch); err != nil {
+			return nil, err
+		}
+		// the ISO was edited once written, its checksum is computed again from the file
+		output.outOfOrder = true
+	}
+	if o.checkVolumeID {
+		created, err := VolumeIdentifier(isoPath)
+		if err != nil {
+			return nil, err
+		}
+		if created != strings.TrimSpace(volumeID) {
+			return nil, fmt.Errorf("volume identifier of the created ISO is %q, expected %q", created, volumeID)
+		}
+	}
+	return output, nil
+}
+
+// tempISOPath returns the path the ISO is created at before being renamed to isoPath
+func tempISOPath(isoPath string) string {
+	return isoPath + ".tmp"
+}
+
+// moveFile renames src to dst. When they are on different devices, src is instead copied to a temporary
+// file in the directory of dst that is synced and renamed to dst, so dst never holds a partial file.
+func moveFile(src, dst string, rename func(oldpath, newpath string) error) (err error) {
+	err = rename(src, dst)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+"-*")
 	if err != nil {
 		return err
 	}
 	defer func() {
-		if deferErr := f.Sync(); deferErr != nil {
-			log.WithError(deferErr).Error("Failed to sync disk image placeholder file")
+		if err != nil {
+			out.Close()
+			os.Remove(out.Name())
 		}
-		if deferErr := f.Close(); deferErr != nil {
-			log.WithError(deferErr).Error("Failed to close disk image placeholder file")
+	}()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if err = out.Chmod(info.Mode()); err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		return err
+	}
+	if err = out.Sync(); err != nil {
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	if err = rename(out.Name(), dst); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// removeRootFS removes the rootfs image of an extracted ISO, extracted trees may preserve the
+// read-only permissions of the ISO so the file and its directory are made writable and the
+// removal retried when it's denied
+func removeRootFS(path string) error {
+	err := os.Remove(path)
+	// the rootfs is already gone when it wasn't extracted or the extract dir is reused for several minimal ISOs
+	if err == nil || os.IsNotExist(err) {
+		return nil
+	}
+	if os.IsPermission(err) {
+		for _, name := range []string{filepath.Dir(path), path} {
+			info, statErr := os.Stat(name)
+			if statErr != nil {
+				continue
+			}
+			if chmodErr := os.Chmod(name, info.Mode().Perm()|0200); chmodErr != nil {
+				return fmt.Errorf("failed to remove rootfs %s: %w (making it writable failed: %v)", path, err, chmodErr)
+			}
+		}
+		err = os.Remove(path)
+		if err == nil || os.IsNotExist(err) {
+			return nil
+		}
+	}
+	return fmt.Errorf("failed to remove rootfs %s: %w", path, err)
+}
+
+// hasIsolinux returns whether the ISOs of the arch have an isolinux config, only ISOs booting
+// BIOS systems have one
+func hasIsolinux(arch string) bool {
+	switch arch {
+	case "ppc64le", "aarch64", "arm64", "s390x":
+		return false
+	}
+	return true
+}
+
+// CreateMinimalISOTemplate Creates the template minimal iso by removing the rootfs and adding the url
+// The arch is detected from the ISO when empty. The build stops before its next stage once ctx is done.
+func (e *rhcosEditor) CreateMinimalISOTemplate(ctx context.Context, fullISOPath, rootFSURL, arch, minimalISOPath string) error {
+	o := newMinimalISOOptions(e.opts...)
+	o.ctx = ctx
+	defer o.startBuild()()
+	if err := o.validateRootFSURL(rootFSURL); err != nil {
+		return err
+	}
+	return e.createMinimalISOTemplate(fullISOPath, rootFSURL, arch, minimalISOPath, o)
+}
+
+func (e *rhcosEditor) createMinimalISOTemplate(fullISOPath, rootFSURL, arch, minimalISOPath string, o *minimalISOOptions) error {
+	o.arch = arch
+	extractDir, err := e.mkdirTemp()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// the extract dir is kept for Close when it can't be removed
+		if err := e.removeTempDir(extractDir); err != nil {
+			o.pathLogger(extractDir).WithError(err).Errorf("Failed to remove %s", extractDir)
 		}
 	}()
 
-	err = f.Truncate(int64(RamDiskPaddingLength))
+	err = o.runStage(StageExtract, func() error {
+		return extractFullISO(fullISOPath, arch, extractDir, o)
+	})
+	if err != nil {
+		return err
+	}
+
+	var volumeID string
+	err = o.runStage(StageVolumeIdentifier, func() error {
+		var idErr error
+		volumeID, idErr = VolumeIdentifier(fullISOPath)
+		return idErr
+	})
+	if err != nil {
+		return err
+	}
+
+	// resolve the arch once so every stage of the build agrees on it
+	if arch == "" {
+		err = o.runStage(StageDetectArch, func() error {
+			var detectErr error
+			arch, detectErr = detectArch(extractDir)
+			return detectErr
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	err = createMinimalISO(extractDir, volumeID, rootFSURL, arch, minimalISOPath, o)
 	if err != nil {
 		return err
 	}
@@ -103,68 +640,1122 @@ func embedInitrdPlaceholders(extractDir string) error {
 	return nil
 }
 
-func fixGrubConfig(rootFSURL, extractDir string) error {
-	availableGrubPaths := []string{"EFI/redhat/grub.cfg", "EFI/fedora/grub.cfg", "boot/grub/grub.cfg", "EFI/centos/grub.cfg"}
-	var foundGrubPath string
-	for _, pathSection := range availableGrubPaths {
-		path := filepath.Join(extractDir, pathSection)
-		if _, err := os.Stat(path); err == nil {
-			foundGrubPath = path
-			break
+// extractFullISO checks that the source is a full ISO the minimal ISO can be built from and extracts it
+func extractFullISO(fullISOPath, arch, extractDir string, o *minimalISOOptions) error {
+	minimal, err := IsMinimalISO(fullISOPath)
+	if err != nil {
+		return err
+	}
+	if minimal {
+		return fmt.Errorf("source is already a minimal ISO: %s", fullISOPath)
+	}
+	if o.validateISO {
+		if err := ValidateISO(fullISOPath); err != nil {
+			return err
 		}
 	}
-	if len(foundGrubPath) == 0 {
-		return fmt.Errorf("no grub.cfg found, possible paths are %v", availableGrubPaths)
+	if err := validateFullISO(fullISOPath, arch); err != nil {
+		return err
 	}
+	return extractExcept(o.ctx, fullISOPath, extractDir, o.skippedFiles())
+}
 
-	// Add the rootfs url
-	replacement := fmt.Sprintf("$1 $2 'coreos.live.rootfs_url=%s'", rootFSURL)
-	if err := editFile(foundGrubPath, `(?m)^(\s+linux) (.+| )+$`, replacement); err != nil {
+// CreateMinimalISOTemplateFromReader creates the template minimal iso from the full ISO read from r, of the
+// given size, e.g. through an object store range reader. Validating the source and reading its volume
+// identifier need random access to the whole ISO, so it's first copied to a temporary file in the data dir
+// of the editor, which needs about the size of the full ISO of free space. Use ExtractFromReader, which
+// only does ranged reads of the files it extracts, to avoid the copy.
+func (e *rhcosEditor) CreateMinimalISOTemplateFromReader(ctx context.Context, r io.ReaderAt, size int64, rootFSURL, arch, minimalISOPath string) error {
+	o := newMinimalISOOptions(e.opts...)
+	o.ctx = ctx
+	// the build timeout includes the copy
+	defer o.startBuild()()
+	if err := o.validateRootFSURL(rootFSURL); err != nil {
 		return err
 	}
 
-	// Remove the coreos.liveiso parameter
-	if err := editFile(foundGrubPath, ` coreos.liveiso=\S+`, ""); err != nil {
+	bufferDir, err := e.mkdirTemp()
+	if err != nil {
 		return err
 	}
+	defer func() {
+		if err := e.removeTempDir(bufferDir); err != nil {
+			o.pathLogger(bufferDir).WithError(err).Errorf("Failed to remove %s", bufferDir)
+		}
+	}()
 
-	// Edit config to add custom ramdisk image to initrd
-	if err := editFile(foundGrubPath, `(?m)^(\s+initrd) (.+| )+$`, fmt.Sprintf("$1 $2 %s", ramDiskImagePath)); err != nil {
+	fullISOPath := filepath.Join(bufferDir, "full.iso")
+	if err = copyReaderAt(o.ctx, fullISOPath, r, size); err != nil {
+		return errors.Wrap(err, "failed to copy the full ISO")
+	}
+	return e.createMinimalISOTemplate(fullISOPath, rootFSURL, arch, minimalISOPath, o)
+}
+
+// copyReaderAt copies the size first bytes of r to a new file at path, it stops once ctx is done
+func copyReaderAt(ctx context.Context, path string, r io.ReaderAt, size int64) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
 		return err
 	}
+	if _, err = io.Copy(f, contextReader{ctx: ctx, r: io.NewSectionReader(r, 0, size)}); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
 
-	return nil
+// contextReader fails the reads once the context is done
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
 }
 
-func fixIsolinuxConfig(rootFSURL, extractDir string) error {
-	replacement := fmt.Sprintf("$1 $2 coreos.live.rootfs_url=%s", rootFSURL)
-	if err := editFile(filepath.Join(extractDir, "isolinux/isolinux.cfg"), `(?m)^(\s+append) (.+| )+$`, replacement); err != nil {
-		return err
+func (r contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+// archMarkers maps files only found in the ISOs of an arch to the arch
+var archMarkers = []struct {
+	pattern string
+	arch    string
+}{
+	{"generic.ins", "s390x"},
+	{"ppc/bootinfo.txt", "ppc64le"},
+	{"EFI/*/*aa64.efi", "aarch64"},
+	{"EFI/*/*AA64.EFI", "aarch64"},
+	{"isolinux/isolinux.bin", "x86_64"},
+	{"EFI/*/*x64.efi", "x86_64"},
+	{"EFI/*/*X64.EFI", "x86_64"},
+}
+
+// detectArch returns the arch of the ISO extracted to the directory
+func detectArch(extractDir string) (string, error) {
+	for _, marker := range archMarkers {
+		matches, err := filepath.Glob(filepath.Join(extractDir, marker.pattern))
+		if err != nil {
+			return "", err
+		}
+		if len(matches) > 0 {
+			return marker.arch, nil
+		}
 	}
+	return "", fmt.Errorf("failed to detect the arch of the ISO extracted to %s", extractDir)
+}
 
-	if err := editFile(filepath.Join(extractDir, "isolinux/isolinux.cfg"), ` coreos.liveiso=\S+`, ""); err != nil {
+// validateFullISO checks that the ISO contains the files needed to build a minimal ISO.
+// s390x ISOs boot through parm files, which are checked when they are edited.
+func validateFullISO(isoPath, arch string) error {
+	d, err := diskfs.Open(isoPath, diskfs.WithOpenMode(diskfs.ReadOnly))
+	if err != nil {
 		return err
 	}
+	defer d.File.Close()
 
-	if err := editFile(filepath.Join(extractDir, "isolinux/isolinux.cfg"), `(?m)^(\s+append.*initrd=\S+) (.*)$`, fmt.Sprintf("${1},%s ${2}", ramDiskImagePath)); err != nil {
+	fs, err := GetISO9660FileSystem(d)
+	if err != nil {
 		return err
 	}
 
+	var missing []string
+	if !isoHasFile(fs, rootFSPathInISO) {
+		missing = append(missing, rootFSPathInISO)
+	}
+	if arch != "s390x" {
+		if !isoHasFile(fs, "images/pxeboot/vmlinuz") {
+			missing = append([]string{"images/pxeboot/vmlinuz"}, missing...)
+		}
+		if !isoHasGrubConfig(fs) {
+			missing = append(missing, fmt.Sprintf("grub.cfg (one of %v)", availableGrubPaths))
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("%s is missing required boot files: %s", isoPath, strings.Join(missing, ", "))
+	}
 	return nil
 }
 
-func editFile(fileName string, reString string, replacement string) error {
-	content, err := os.ReadFile(fileName)
+// ErrInvalidISO is returned by ValidateISO for an ISO that isn't a bootable RHCOS live ISO
+type ErrInvalidISO struct {
+	Path string
+	// Missing are the expected elements the ISO doesn't have
+	Missing []string
+}
+
+func (e *ErrInvalidISO) Error() string {
+	return fmt.Sprintf("%s is not a bootable RHCOS live ISO, missing %s", e.Path, strings.Join(e.Missing, ", "))
+}
+
+// ValidateISO checks that the ISO is a bootable RHCOS live ISO, with a rootfs, a grub config and
+// the coreos.liveiso kernel argument. An ErrInvalidISO listing the missing elements is returned
+// otherwise, and the error of opening the ISO for a file that isn't one, e.g. a truncated download.
+func ValidateISO(isoPath string) error {
+	d, err := diskfs.Open(isoPath, diskfs.WithOpenMode(diskfs.ReadOnly))
 	if err != nil {
 		return err
 	}
+	defer d.File.Close()
 
-	re := regexp.MustCompile(reString)
-	newContent := re.ReplaceAllString(string(content), replacement)
+	fs, err := GetISO9660FileSystem(d)
+	if err != nil {
+		return errors.Wrapf(err, "failed to read %s as an ISO", isoPath)
+	}
 
-	if err := os.WriteFile(fileName, []byte(newContent), 0600); err != nil {
-		return err
+	var missing []string
+	if !isoHasFile(fs, rootFSPathInISO) {
+		missing = append(missing, rootFSPathInISO)
+	}
+	if !isoHasGrubConfig(fs) {
+		missing = append(missing, "grub.cfg")
+	}
+	// the boot configs not being found or not having a kernel command line is reported as the
+	// argument missing
+	args, _ := ReadKernelArgs(isoPath)
+	if !hasKernelArg(args, "coreos.liveiso") {
+		missing = append(missing, "coreos.liveiso kernel argument")
 	}
 
+	if len(missing) > 0 {
+		return &ErrInvalidISO{Path: isoPath, Missing: missing}
+	}
 	return nil
 }
+
+// isoHasGrubConfig returns true if the iso filesystem has a grub.cfg at one of the availableGrubPaths
+// or in one of the grubConfigDirs
+func isoHasGrubConfig(fs filesystem.FileSystem) bool {
+	_, found := isoGrubConfig(fs)
+	return found
+}
+
+// isoGrubConfig returns the path within the iso filesystem of the grub config findGrubConfig finds
+// once the iso is extracted, false when there is none
+func isoGrubConfig(fs filesystem.FileSystem) (string, bool) {
+	for _, f := range availableGrubPaths {
+		if isoHasFile(fs, f) {
+			return f, true
+		}
+	}
+
+	for _, dir := range grubConfigDirs {
+		sizes := map[string]int64{}
+		// the directory may be missing, only the files found before an error matter
+		_ = listAll(fs, "/"+dir, sizes)
+		var found []string
+		for filePath := range sizes {
+			if path.Base(filePath) == "grub.cfg" {
+				found = append(found, strings.TrimPrefix(filePath, "/"))
+			}
+		}
+		if len(found) > 0 {
+			sort.Slice(found, func(i, j int) bool { return walkLess(found[i], found[j]) })
+			return found[0], true
+		}
+	}
+	return "", false
+}
+
+// walkLess returns true if filepath.WalkDir visits the path a before the path b
+func walkLess(a, b string) bool {
+	aElements, bElements := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(aElements) && i < len(bElements); i++ {
+		if aElements[i] != bElements[i] {
+			return aElements[i] < bElements[i]
+		}
+	}
+	return len(aElements) < len(bElements)
+}
+
+// findISOGrubConfig returns the path within the ISO of its grub config, see isoGrubConfig
+func findISOGrubConfig(isoPath string) (string, bool, error) {
+	d, err := diskfs.Open(isoPath, diskfs.WithOpenMode(diskfs.ReadOnly))
+	if err != nil {
+		return "", false, err
+	}
+	defer d.File.Close()
+
+	fs, err := GetISO9660FileSystem(d)
+	if err != nil {
+		return "", false, errors.Wrapf(err, "failed to read %s as an ISO", isoPath)
+	}
+	grubPath, found := isoGrubConfig(fs)
+	return grubPath, found, nil
+}
+
+// CreateMinimalISOs creates several minimal ISOs, differing only by their rootfs URL, from a single
+// extraction of the full ISO. rootFSURLs maps the path of each minimal ISO to its rootfs URL.
+// The arch is detected from the ISO once extracted when empty. The full ISO is extracted to a temporary
+// directory under os.TempDir, or the one set with WithTempDir, and each minimal ISO is built from a
+// clone of the extraction so the edits of one don't leak into the others.
+// The result of each build is returned by minimal ISO path, WithBuildResult and WithDryRun, which hold
+// a single build, are rejected.
+func CreateMinimalISOs(fullISOPath, arch string, rootFSURLs map[string]string, opts ...MinimalISOOption) (map[string]BuildResult, error) {
+	o := newMinimalISOOptions(opts...)
+	if o.result != nil || o.dryRun != nil {
+		return nil, fmt.Errorf("build results and dry runs can't be used with several minimal ISOs")
+	}
+	defer o.startBuild()()
+	for _, rootFSURL := range rootFSURLs {
+		if err := o.validateRootFSURL(rootFSURL); err != nil {
+			return nil, err
+		}
+	}
+
+	extractDir, err := os.MkdirTemp(o.tempDir, "isoutil")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := os.RemoveAll(extractDir); err != nil {
+			o.pathLogger(extractDir).WithError(err).Errorf("Failed to remove %s", extractDir)
+		}
+	}()
+
+	err = o.runStage(StageExtract, func() error {
+		return extractFullISO(fullISOPath, arch, extractDir, o)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var volumeID string
+	err = o.runStage(StageVolumeIdentifier, func() error {
+		var idErr error
+		volumeID, idErr = VolumeIdentifier(fullISOPath)
+		return idErr
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// resolve the arch once for all the minimal ISOs
+	if arch == "" {
+		err = o.runStage(StageDetectArch, func() error {
+			var detectErr error
+			arch, detectErr = detectArch(extractDir)
+			return detectErr
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	minimalISOPaths := make([]string, 0, len(rootFSURLs))
+	for minimalISOPath := range rootFSURLs {
+		minimalISOPaths = append(minimalISOPaths, minimalISOPath)
+	}
+	sort.Strings(minimalISOPaths)
+
+	results := make(map[string]BuildResult, len(minimalISOPaths))
+	for _, minimalISOPath := range minimalISOPaths {
+		// each build report only has the stages of its own build, and the progress of each build starts
+		// with the shared extraction done
+		o.stageTimings = nil
+		o.progressDone = stageWeights[StageExtract] + stageWeights[StageVolumeIdentifier]
+		var result BuildResult
+		o.result = &result
+		err = createMinimalISOFromClone(extractDir, volumeID, rootFSURLs[minimalISOPath], arch, minimalISOPath, o)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to create minimal ISO %s", minimalISOPath)
+		}
+		results[minimalISOPath] = result
+	}
+
+	return results, nil
+}
+
+// createMinimalISOFromClone creates the minimal ISO from a clone of the extract dir, which is left as is
+func createMinimalISOFromClone(extractDir, volumeID, rootFSURL, arch, minimalISOPath string, o *minimalISOOptions) error {
+	clone, err := cloneExtractDir(extractDir, o)
+	if err != nil {
+		return err
+	}
+	// diskfs removes the clone once the ISO is created, it's left behind by the builds failing before
+	defer func() {
+		if err := os.RemoveAll(clone); err != nil {
+			o.pathLogger(clone).WithError(err).Errorf("Failed to remove %s", clone)
+		}
+	}()
+	return createMinimalISO(clone, volumeID, rootFSURL, arch, minimalISOPath, o)
+}
+
+// cloneExtractDir clones the extract dir to a new directory under the temp dir of the build. The files
+// the build rewrites, the boot configs and the custom ramdisk image placeholder, are copied and the
+// others hard linked, or copied when the temp dir is on another filesystem. Every file is copied for
+// builds with WithSourceDateEpoch, which normalize the attributes hard links share with extractDir.
+// The directories are made writable by their owner so the build and diskfs can remove their files.
+func cloneExtractDir(extractDir string, o *minimalISOOptions) (string, error) {
+	configs, err := readBootConfigs(extractDir)
+	if err != nil {
+		return "", err
+	}
+	copied := map[string]bool{filepath.Join(extractDir, ramDiskImagePath): true}
+	for path := range configs {
+		copied[path] = true
+	}
+	skipped := map[string]bool{}
+	for _, path := range o.skippedFiles() {
+		skipped[filepath.Join(extractDir, path)] = true
+	}
+
+	clone, err := os.MkdirTemp(o.tempDir, "extract")
+	if err != nil {
+		return "", err
+	}
+	err = filepath.WalkDir(extractDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || skipped[path] {
+			return err
+		}
+		rel, err := filepath.Rel(extractDir, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(clone, rel)
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case entry.IsDir():
+			if rel == "." {
+				return os.Chmod(clone, info.Mode().Perm()|0700)
+			}
+			return os.Mkdir(target, info.Mode().Perm()|0700)
+		case entry.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case !copied[path] && o.sourceDateEpoch == nil:
+			if err := os.Link(path, target); err == nil {
+				return nil
+			}
+		}
+		return copyLocalFile(path, target, info)
+	})
+	if err != nil {
+		if removeErr := os.RemoveAll(clone); removeErr != nil {
+			o.pathLogger(clone).WithError(removeErr).Errorf("Failed to remove %s", clone)
+		}
+		return "", err
+	}
+	return clone, nil
+}
+
+// copyLocalFile copies the regular file src, described by info, to the new file dst with the same
+// permissions and modification time
+func copyLocalFile(src, dst string, info fs.FileInfo) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	return os.Chtimes(dst, info.ModTime(), info.ModTime())
+}
+
+// readBootConfigs returns the content of the boot config files found in the extract dir by path
+func readBootConfigs(extractDir string) (map[string][]byte, error) {
+	configs := map[string][]byte{}
+	paths, err := filepath.Glob(filepath.Join(extractDir, "images/*.prm"))
+	if err != nil {
+		return nil, err
+	}
+	paths = append(paths, filepath.Join(extractDir, "isolinux/isolinux.cfg"))
+	if grubPath, err := findGrubConfig(extractDir); err == nil {
+		paths = append(paths, grubPath)
+	}
+	for _, path := range paths {
+		content, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		configs[path] = content
+	}
+	return configs, nil
+}
+
+// ErrPlaceholderCreate is returned when the placeholder for the custom ramdisk image can't be created
+type ErrPlaceholderCreate struct {
+	Path string
+	Err  error
+}
+
+func (e *ErrPlaceholderCreate) Error() string {
+	return fmt.Sprintf("failed to create placeholder %s: %v", e.Path, e.Err)
+}
+
+func (e *ErrPlaceholderCreate) Unwrap() error {
+	return e.Err
+}
+
+// embedInitrdPlaceholders creates the custom ramdisk image placeholder holding the content padded with zeros
+func embedInitrdPlaceholders(extractDir string, content []byte, length uint64, logger log.FieldLogger) error {
+	path := filepath.Join(extractDir, ramDiskImagePath)
+	f, err := os.Create(path)
+	if err != nil {
+		return &ErrPlaceholderCreate{Path: path, Err: err}
+	}
+	defer func() {
+		if deferErr := f.Sync(); deferErr != nil {
+			logger.WithError(deferErr).Error("Failed to sync disk image placeholder file")
+		}
+		if deferErr := f.Close(); deferErr != nil {
+			logger.WithError(deferErr).Error("Failed to close disk image placeholder file")
+		}
+	}()
+
+	if _, err = f.Write(content); err != nil {
+		return &ErrPlaceholderCreate{Path: path, Err: err}
+	}
+	err = f.Truncate(int64(length))
+	if err != nil {
+		return &ErrPlaceholderCreate{Path: path, Err: err}
+	}
+
+	return nil
+}
+
+// isolinuxAppendRe matches the isolinux append lines loading an initrd, it is shared by
+// concurrent edits and must only be used for matching
+var isolinuxAppendRe = regexp.MustCompile(`(?m)^\s+append .*initrd=\S+`)
+
+var availableGrubPaths = []string{"EFI/redhat/grub.cfg", "EFI/fedora/grub.cfg", "boot/grub/grub.cfg", "EFI/centos/grub.cfg"}
+
+// grubConfigDirs are the directories searched for a grub.cfg when it isn't at any of the availableGrubPaths
+var grubConfigDirs = []string{"EFI", "boot"}
+
+// findGrubConfig returns the path of the grub config of the extract dir. The availableGrubPaths are checked
+// first, then the grubConfigDirs are searched for the first grub.cfg in lexical order, e.g. for the ISOs of
+// rebranded distros.
+func findGrubConfig(extractDir string) (string, error) {
+	for _, pathSection := range availableGrubPaths {
+		path := filepath.Join(extractDir, pathSection)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		}
+	}
+
+	for _, dir := range grubConfigDirs {
+		var found string
+		err := filepath.WalkDir(filepath.Join(extractDir, dir), func(path string, d os.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if !d.IsDir() && d.Name() == "grub.cfg" {
+				found = path
+				return filepath.SkipAll
+			}
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+		if found != "" {
+			return found, nil
+		}
+	}
+	return "", fmt.Errorf("no grub.cfg found, possible paths are %v or any grub.cfg in %v", availableGrubPaths, grubConfigDirs)
+}
+
+// isKnownGrubConfig returns whether the grub config found in the extract dir is at one of the availableGrubPaths
+func isKnownGrubConfig(extractDir, grubPath string) bool {
+	rel, err := filepath.Rel(extractDir, grubPath)
+	return err == nil && funk.ContainsString(availableGrubPaths, filepath.ToSlash(rel))
+}
+
+// ErrNoBootConfig is returned when an ISO, or the directory it was extracted to, has none of the
+// boot configs looked for
+type ErrNoBootConfig struct {
+	// Path is the ISO or extract dir
+	Path  string
+	Paths []string
+}
+
+func (e *ErrNoBootConfig) Error() string {
+	return fmt.Sprintf("no boot config found in %s, possible paths are %v", e.Path, e.Paths)
+}
+
+// checkBootConfigs returns an ErrNoBootConfig when the extract dir has no boot config the build can edit
+func checkBootConfigs(extractDir string) error {
+	if _, err := findGrubConfig(extractDir); err == nil {
+		return nil
+	}
+	paths := append(append([]string{}, availableGrubPaths...), "isolinux/isolinux.cfg", "images/*.prm")
+	for _, path := range paths {
+		matches, err := filepath.Glob(filepath.Join(extractDir, path))
+		if err != nil {
+			return err
+		}
+		if len(matches) > 0 {
+			return nil
+		}
+	}
+	return &ErrNoBootConfig{Path: extractDir, Paths: paths}
+}
+
+// grubKernelArgsRe and isolinuxKernelArgsRe match the kernel command lines of the boot configs,
+// the arguments being the first group
+var (
+	grubKernelArgsRe     = regexp.MustCompile(`(?m)^\s*linux\s+\S+(.*)$`)
+	isolinuxKernelArgsRe = regexp.MustCompile(`(?m)^\s*append\s+(.*)$`)
+)
+
+// ReadKernelArgs returns the kernel arguments of the ISO, read from the first kernel command line of
+// its grub config or, for ISOs without one, of its isolinux config. An ErrNoBootConfig is returned
+// when the ISO has neither, and the error of a config that can't be read is returned as is.
+func ReadKernelArgs(isoPath string) ([]string, error) {
+	return readKernelArgs(isoPath, ExtractFile)
+}
+
+// readKernelArgs is ReadKernelArgs reading the boot configs with extractFile
+func readKernelArgs(isoPath string, extractFile func(isoPath, internalPath string) ([]byte, error)) ([]string, error) {
+	if _, err := os.Stat(isoPath); err != nil {
+		return nil, err
+	}
+
+	var paths []string
+	read := func(path string, re *regexp.Regexp) ([]string, bool, error) {
+		paths = append(paths, path)
+		content, err := extractFile(isoPath, path)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, false, nil
+		}
+		if err != nil {
+			return nil, true, err
+		}
+		match := re.FindSubmatch(content)
+		if match == nil {
+			return nil, true, fmt.Errorf("no kernel command line found in %s", path)
+		}
+		var args []string
+		for _, arg := range strings.Fields(string(match[1])) {
+			// grub arguments holding URLs are quoted by fixGrubConfig
+			if len(arg) > 1 && (arg[0] == '\'' || arg[0] == '"') && arg[len(arg)-1] == arg[0] {
+				arg = arg[1 : len(arg)-1]
+			}
+			args = append(args, arg)
+		}
+		return args, true, nil
+	}
+
+	grubPath, found, err := findISOGrubConfig(isoPath)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		if args, found, err := read(grubPath, grubKernelArgsRe); found {
+			return args, err
+		}
+	}
+	if args, found, err := read("isolinux/isolinux.cfg", isolinuxKernelArgsRe); found {
+		return args, err
+	}
+	paths = append(append(append([]string{}, availableGrubPaths...), grubConfigDirs...), paths...)
+	return nil, &ErrNoBootConfig{Path: isoPath, Paths: paths}
+}
+
+// GetRootFSURL returns the rootfs URL set in the grub config of a minimal ISO
+func GetRootFSURL(isoPath string, opts ...MinimalISOOption) (string, error) {
+	o := newMinimalISOOptions(opts...)
+	re := regexp.MustCompile(regexp.QuoteMeta(o.rootFSArgKey) + `=([^\s']+)`)
+	grubPath, found, err := findISOGrubConfig(isoPath)
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "", fmt.Errorf("no grub.cfg found, possible paths are %v or any grub.cfg in %v", availableGrubPaths, grubConfigDirs)
+	}
+	content, err := ReadFileFromISO(isoPath, "/"+grubPath)
+	if err != nil {
+		return "", err
+	}
+	match := re.FindSubmatch(content)
+	if match == nil {
+		return "", fmt.Errorf("no %s argument found in %s", o.rootFSArgKey, grubPath)
+	}
+	return string(match[1]), nil
+}
+
+// fixGrubConfig edits the grub config of the extracted ISO for the minimal ISO. Like the other
+// boot config edits it only touches files within extractDir and only reads the package level
+// vars, so edits of different extract dirs can run concurrently.
+func fixGrubConfig(rootFSURL, extractDir string, o *minimalISOOptions) ([]BuildWarning, error) {
+	foundGrubPath, err := findGrubConfig(extractDir)
+	if err != nil {
+		return nil, err
+	}
+	if !isKnownGrubConfig(extractDir, foundGrubPath) {
+		o.pathLogger(foundGrubPath).Infof("Using the grub config found at %s", foundGrubPath)
+	}
+
+	// Remove the coreos.liveiso parameter, and the rootfs url of a previous edit so that editing
+	// the config again replaces it, the initrd images are moved by insertInitrdImages. An embedded
+	// rootfs is found through coreos.liveiso.
+	if !o.embedRootFS {
+		// the device label set by a previous edit is quoted like the rootfs url
+		if _, err := editFile(foundGrubPath, ` '?coreos.liveiso=[^\s']+'?`, ""); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := editFile(foundGrubPath, ` '?`+regexp.QuoteMeta(o.rootFSArgKey)+`=[^\s']+'?`, ""); err != nil {
+		return nil, err
+	}
+
+	// Add the rootfs url
+	if !o.embedRootFS {
+		replacement := fmt.Sprintf("$1 $2 '%s'", o.rootFSArg(rootFSURL))
+		if n, err := editFile(foundGrubPath, `(?m)^(\s+linux) (.+| )+$`, replacement); err != nil {
+			return nil, err
+		} else if n == 0 {
+			return nil, fmt.Errorf("no linux line found in %s", foundGrubPath)
+		}
+	}
+
+	// Edit config to add custom ramdisk image to initrd
+	if n, err := insertInitrdImages(foundGrubPath, `(?m)^(\s+initrd )(.*\S)(\s*)$`, " ", o.initrdImages(), o.initrdPosition); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, fmt.Errorf("no initrd line found in %s", foundGrubPath)
+	}
+
+	kept, err := ensureKernelArgs(foundGrubPath, `(?m)^\s+linux .+$`, o.kernelArgs())
+	if err != nil {
+		return nil, err
+	}
+	if err := editKernelArgs(foundGrubPath, `(?m)^\s+linux .+$`, o.addedKernelArgs, o.removedKernelArgs); err != nil {
+		return nil, err
+	}
+
+	if err := validateGrubConfig(foundGrubPath); err != nil {
+		return nil, err
+	}
+	return keptKernelArgWarnings(extractDir, foundGrubPath, kept), nil
+}
+
+// fixPrmConfigs edits the kernel arguments in the parm files used to boot s390x systems
+func fixPrmConfigs(rootFSURL, extractDir string, o *minimalISOOptions) error {
+	prmPaths, err := filepath.Glob(filepath.Join(extractDir, "images/*.prm"))
+	if err != nil {
+		return err
+	}
+	if len(prmPaths) == 0 {
+		return fmt.Errorf("no parm file found in %s", filepath.Join(extractDir, "images"))
+	}
+
+	for _, prmPath := range prmPaths {
+		content, err := readConfigFile(prmPath)
+		if err != nil {
+			return err
+		}
+
+		// parm files hold a single kernel command line, possibly split across lines
+		var args []string
+		for _, field := range strings.Fields(content) {
+			if o.embedRootFS || !strings.HasPrefix(field, "coreos.liveiso=") {
+				args = append(args, field)
+			}
+		}
+		added := o.kernelArgs()
+		if !o.embedRootFS {
+			added = append([]string{o.rootFSArg(rootFSURL)}, added...)
+		}
+		for _, arg := range added {
+			if !hasKernelArg(args, strings.SplitN(arg, "=", 2)[0]) {
+				args = append(args, arg)
+			}
+		}
+		args = editKernelArgFields(args, o.addedKernelArgs, o.removedKernelArgs)
+
+		if err := os.WriteFile(prmPath, []byte(strings.Join(args, " ")+"\n"), 0600); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateGrubConfig catches edits that broke the grub config, which would otherwise only fail at boot.
+// It checks that quotes and braces are balanced and that linux and initrd commands have their paths.
+func validateGrubConfig(fileName string) error {
+	content, err := readConfigFile(fileName)
+	if err != nil {
+		return err
+	}
+
+	depth := 0
+	for i, line := range strings.Split(content, "\n") {
+		lineNumber := i + 1
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		var quote rune
+		escaped := false
+		for _, c := range trimmed {
+			switch {
+			case escaped:
+				escaped = false
+			case quote == '\'':
+				if c == '\'' {
+					quote = 0
+				}
+			case c == '\\':
+				escaped = true
+			case quote == '"':
+				if c == '"' {
+					quote = 0
+				}
+			case c == '\'' || c == '"':
+				quote = c
+			case c == '{':
+				depth++
+			case c == '}':
+				depth--
+				if depth < 0 {
+					return fmt.Errorf("%s: unexpected } on line %d", fileName, lineNumber)
+				}
+			}
+		}
+		if quote != 0 {
+			return fmt.Errorf("%s: unterminated %c quote on line %d", fileName, quote, lineNumber)
+		}
+
+		fields := strings.Fields(trimmed)
+		switch fields[0] {
+		case "linux", "linuxefi", "linux16":
+			if len(fields) < 2 || !isGrubPath(fields[1]) {
+				return fmt.Errorf("%s: %s command without a kernel path on line %d", fileName, fields[0], lineNumber)
+			}
+		case "initrd", "initrdefi", "initrd16":
+			if len(fields) < 2 {
+				return fmt.Errorf("%s: %s command without an initrd path on line %d", fileName, fields[0], lineNumber)
+			}
+			for _, path := range fields[1:] {
+				if !isGrubPath(path) {
+					return fmt.Errorf("%s: invalid initrd path %q on line %d", fileName, path, lineNumber)
+				}
+			}
+		}
+	}
+	if depth != 0 {
+		return fmt.Errorf("%s: %d unclosed {", fileName, depth)
+	}
+
+	return nil
+}
+
+// isGrubPath returns whether the word is an absolute path, possibly prefixed by a device or variable
+func isGrubPath(word string) bool {
+	return strings.HasPrefix(word, "/") || strings.HasPrefix(word, "(") || strings.HasPrefix(word, "$")
+}
+
+// fixIsolinuxConfig edits the isolinux config of the extracted ISO for the minimal ISO
+func fixIsolinuxConfig(rootFSURL, extractDir string, o *minimalISOOptions) ([]BuildWarning, error) {
+	isolinuxPath := filepath.Join(extractDir, "isolinux/isolinux.cfg")
+
+	if exists, err := fileExists(isolinuxPath); err != nil {
+		return nil, err
+	} else if !exists {
+		return []BuildWarning{{
+			Kind:    BuildWarningMissingConfig,
+			Path:    "isolinux/isolinux.cfg",
+			Message: "isolinux config not found, BIOS systems won't boot the minimal ISO with the rootfs URL",
+		}}, nil
+	}
+
+	// the edits below match nothing without an append line, which would leave an isolinux config that can't find the rootfs
+	content, err := readConfigFile(isolinuxPath)
+	if err != nil {
+		return nil, err
+	}
+	if !isolinuxAppendRe.MatchString(content) {
+		return nil, fmt.Errorf("no append line with an initrd found in %s", isolinuxPath)
+	}
+
+	if !o.embedRootFS {
+		if _, err := editFile(isolinuxPath, ` '?coreos.liveiso=[^\s']+'?`, ""); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := editFile(isolinuxPath, ` `+regexp.QuoteMeta(o.rootFSArgKey)+`=\S+`, ""); err != nil {
+		return nil, err
+	}
+
+	if !o.embedRootFS {
+		replacement := fmt.Sprintf("$1 $2 %s", o.rootFSArg(rootFSURL))
+		if n, err := editFile(isolinuxPath, `(?m)^(\s+append) (.+| )+$`, replacement); err != nil {
+			return nil, err
+		} else if n == 0 {
+			return nil, fmt.Errorf("no append line found in %s", isolinuxPath)
+		}
+	}
+
+	if n, err := insertInitrdImages(isolinuxPath, `(?m)^(\s+append.*initrd=)(\S+)(.*)$`, ",", o.initrdImages(), o.initrdPosition); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, fmt.Errorf("no append line with an initrd found in %s", isolinuxPath)
+	}
+
+	kept, err := ensureKernelArgs(isolinuxPath, `(?m)^\s+append .+$`, o.kernelArgs())
+	if err != nil {
+		return nil, err
+	}
+	if err := editKernelArgs(isolinuxPath, `(?m)^\s+append .+$`, o.addedKernelArgs, o.removedKernelArgs); err != nil {
+		return nil, err
+	}
+
+	return keptKernelArgWarnings(extractDir, isolinuxPath, kept), nil
+}
+
+// insertInitrdImages inserts the images in order at the position in the initrd images of the lines
+// matching lineRe, whose second group is the list of images separated by sep. The images added by a
+// previous edit are removed first. It returns the number of lines edited.
+func insertInitrdImages(fileName, lineRe, sep string, inserted []string, position *InitrdPosition) (int, error) {
+	content, err := readConfigFile(fileName)
+	if err != nil {
+		return 0, err
+	}
+
+	var count int
+	var insertErr error
+	re := regexp.MustCompile(lineRe)
+	newContent := re.ReplaceAllStringFunc(content, func(line string) string {
+		match := re.FindStringSubmatch(line)
+		var images []string
+		for _, image := range strings.Split(match[2], sep) {
+			if image != "" && !funk.ContainsString(inserted, image) {
+				images = append(images, image)
+			}
+		}
+		images, err := position.insert(images, inserted)
+		if err != nil {
+			if insertErr == nil {
+				insertErr = fmt.Errorf("failed to add the initrd images to %s: %w", fileName, err)
+			}
+			return line
+		}
+		count++
+		return match[1] + strings.Join(images, sep) + match[3]
+	})
+	if insertErr != nil {
+		return 0, insertErr
+	}
+
+	return count, os.WriteFile(fileName, []byte(newContent), 0600)
+}
+
+// keptKernelArgWarnings returns a warning for each kernel argument left with the value set in the boot config
+func keptKernelArgWarnings(extractDir, fileName string, kept []string) []BuildWarning {
+	path, err := filepath.Rel(extractDir, fileName)
+	if err != nil {
+		path = fileName
+	}
+	var warnings []BuildWarning
+	for _, arg := range kept {
+		warnings = append(warnings, BuildWarning{
+			Kind:    BuildWarningKernelArgKept,
+			Path:    path,
+			Message: fmt.Sprintf("kernel argument %s is already set to another value and was left untouched", arg),
+		})
+	}
+	return warnings
+}
+
+func setGrubTimeout(extractDir string, timeout time.Duration) error {
+	grubPath, err := findGrubConfig(extractDir)
+	if err != nil {
+		return err
+	}
+	return setTimeout(grubPath, `(?m)^(\s*)set timeout=\S*$`, fmt.Sprintf("set timeout=%d", int64(timeout.Seconds())))
+}
+
+func setIsolinuxTimeout(extractDir string, timeout time.Duration) error {
+	// isolinux expects the timeout in units of 1/10s
+	return setTimeout(filepath.Join(extractDir, "isolinux/isolinux.cfg"), `(?m)^(\s*)timeout \d+$`, fmt.Sprintf("timeout %d", timeout.Milliseconds()/100))
+}
+
+// setTimeout replaces the existing timeout setting, or adds it at the top of the file if there is none
+func setTimeout(fileName string, reString string, setting string) error {
+	content, err := readConfigFile(fileName)
+	if err != nil {
+		return err
+	}
+
+	var newContent string
+	re := regexp.MustCompile(reString)
+	if re.MatchString(content) {
+		newContent = re.ReplaceAllString(content, "${1}"+setting)
+	} else {
+		newContent = setting + "\n" + content
+	}
+
+	return os.WriteFile(fileName, []byte(newContent), 0600)
+}
+
+// ignitionArgs make the live system run ignition using the configs embedded in the initrd
+// images, including the one later written to the custom ramdisk image placeholder
+func ignitionArgs(platformID string) []string {
+	return []string{"ignition.firstboot", "ignition.platform.id=" + platformID}
+}
+
+// copyNetworkArgs are the accepted forms of the kernel argument making coreos-installer
+// copy the network config of the live system to the installed system
+var copyNetworkArgs = []string{"coreos.inst.copy_network", "coreos.inst.copy_network=1", "coreos.inst.copy_network=true"}
+
+// ensureKernelArgs appends the arguments missing from the kernel command lines matching lineRe.
+// Arguments already present with a different value are left untouched and returned.
+func ensureKernelArgs(fileName string, lineRe string, args []string) ([]string, error) {
+	content, err := readConfigFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	var kept []string
+	re := regexp.MustCompile(lineRe)
+	newContent := re.ReplaceAllStringFunc(content, func(line string) string {
+		fields := strings.Fields(line)
+		for _, arg := range args {
+			if !hasKernelArg(fields, strings.SplitN(arg, "=", 2)[0]) {
+				line = fmt.Sprintf("%s %s", line, arg)
+			} else if strings.Contains(arg, "=") && !hasKernelArg(fields, arg) && !funk.ContainsString(kept, arg) {
+				kept = append(kept, arg)
+			}
+		}
+		return line
+	})
+
+	return kept, os.WriteFile(fileName, []byte(newContent), 0600)
+}
+
+// editKernelArgs removes and sets the kernel arguments of the kernel command lines matching lineRe,
+// see editKernelArgFields
+func editKernelArgs(fileName string, lineRe string, add, remove []string) error {
+	if len(add) == 0 && len(remove) == 0 {
+		return nil
+	}
+	content, err := readConfigFile(fileName)
+	if err != nil {
+		return err
+	}
+
+	re := regexp.MustCompile(lineRe)
+	newContent := re.ReplaceAllStringFunc(content, func(line string) string {
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t\n"))]
+		fields := strings.Fields(line)
+		// the command, and the kernel path of grub lines, aren't arguments
+		commandFields := 1
+		if fields[0] == "linux" {
+			commandFields = 2
+		}
+		if len(fields) < commandFields {
+			return line
+		}
+		args := editKernelArgFields(fields[commandFields:], add, remove)
+		return indent + strings.Join(append(fields[:commandFields:commandFields], args...), " ")
+	})
+
+	return os.WriteFile(fileName, []byte(newContent), 0600)
+}
+
+// editKernelArgFields returns the kernel arguments without the ones matching remove, and with the
+// ones of add replacing those with the same keys
+func editKernelArgFields(fields []string, add, remove []string) []string {
+	addKeys := kernelArgKeys(add)
+	var args []string
+	for _, field := range fields {
+		arg := strings.Trim(field, "'")
+		if !matchesKernelArg(arg, remove) && !matchesKernelArg(arg, addKeys) {
+			args = append(args, field)
+		}
+	}
+	return append(args, add...)
+}
+
+// matchesKernelArg returns whether the argument is one of the patterns, a bare key pattern matching
+// the argument with any value
+func matchesKernelArg(arg string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if arg == pattern || (!strings.Contains(pattern, "=") && strings.HasPrefix(arg, pattern+"=")) {
+			return true
+		}
+	}
+	return false
+}
+
+// kernelArgKeys returns the keys of the kernel arguments
+func kernelArgKeys(args []string) []string {
+	keys := make([]string, 0, len(args))
+	for _, arg := range args {
+		keys = append(keys, strings.SplitN(arg, "=", 2)[0])
+	}
+	return keys
+}
+
+func hasKernelArg(fields []string, key string) bool {
+	for _, field := range fields {
+		field = strings.Trim(field, "'")
+		if field == key || strings.HasPrefix(field, key+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// readConfigFile reads a boot config file, refusing anything that isn't plain text
+// since editing it with regular expressions would corrupt it
+func readConfigFile(fileName string) (string, error) {
+	content, err := os.ReadFile(fileName)
+	if err != nil {
+		return "", err
+	}
+
+	if bytes.HasPrefix(content, gzipMagic) {
+		return "", fmt.Errorf("%s is gzip compressed, only plain text configs can be edited", fileName)
+	}
+	if !utf8.Valid(content) || bytes.IndexByte(content, 0) != -1 {
+		return "", fmt.Errorf("%s is not a plain text file", fileName)
+	}
+
+	return string(content), nil
+}
+
+// editFile replaces the matches of reString in the file and returns the number of replacements made
+func editFile(fileName string, reString string, replacement string) (int, error) {
+	content, err := readConfigFile(fileName)
+	if err != nil {
+		return 0, err
+	}
+
+	re := regexp.MustCompile(reString)
+	count := len(re.FindAllStringIndex(content, -1))
+	newContent := re.ReplaceAllString(content, replacement)
+
+	if err := os.WriteFile(fileName, []byte(newContent), 0600); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
@@ -1,13 +1,26 @@
 package isoeditor
 
 import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/rand"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
 )
 
 const (
@@ -15,6 +28,42 @@ const (
 	testFCOSRootFSURL = "https://builds.coreos.fedoraproject.org/prod/streams/stable/builds/35.20220103.3.0/x86_64/fedora-coreos-35.20220103.3.0-live-rootfs.x86_64.img"
 )
 
+// countdownContext reports being canceled once Err has been called remaining times
+type countdownContext struct {
+	context.Context
+	remaining int
+}
+
+func (c *countdownContext) Err() error {
+	if c.remaining == 0 {
+		return context.Canceled
+	}
+	c.remaining--
+	return nil
+}
+
+// fileContext counts the calls to Err made while the file at path exists and reports being canceled
+// from the cancelAt-th one, when it is set
+type fileContext struct {
+	context.Context
+	path     string
+	cancelAt int
+	calls    int
+}
+
+func (c *fileContext) Err() error {
+	if c.cancelAt > 0 && c.calls >= c.cancelAt {
+		return context.Canceled
+	}
+	if _, err := os.Stat(c.path); err == nil {
+		c.calls++
+	}
+	if c.cancelAt > 0 && c.calls >= c.cancelAt {
+		return context.Canceled
+	}
+	return nil
+}
+
 var _ = Context("with test files", func() {
 	var (
 		isoFile        string
@@ -24,12 +73,9 @@ var _ = Context("with test files", func() {
 		volumeID       = "Assisted123"
 	)
 
-	validateFileContainsLine := func(filename string, content string) {
-		fileContent, err := os.ReadFile(filename)
-		Expect(err).NotTo(HaveOccurred())
-
+	validateContainsLine := func(filename string, fileContent string, content string) {
 		found := false
-		for _, line := range strings.Split(string(fileContent), "\n") {
+		for _, line := range strings.Split(fileContent, "\n") {
 			if line == content {
 				found = true
 				break
@@ -38,6 +84,24 @@ var _ = Context("with test files", func() {
 		Expect(found).To(BeTrue(), "Failed to find required string `%s` in file `%s`", content, filename)
 	}
 
+	validateFileContainsLine := func(filename string, content string) {
+		fileContent, err := os.ReadFile(filename)
+		Expect(err).NotTo(HaveOccurred())
+		validateContainsLine(filename, string(fileContent), content)
+	}
+
+	// readMinimalISOFile returns a file of the minimal iso, the extracted files it's built from being
+	// removed by the build
+	readMinimalISOFile := func(filePath string) string {
+		content, err := ReadFileFromISO(minimalISOPath, "/"+filePath)
+		Expect(err).NotTo(HaveOccurred())
+		return string(content)
+	}
+
+	validateMinimalISOFileContainsLine := func(filePath string, content string) {
+		validateContainsLine(filePath, readMinimalISOFile(filePath), content)
+	}
+
 	BeforeEach(func() {
 		filesDir, isoFile = createTestFiles(volumeID)
 
@@ -57,14 +121,277 @@ var _ = Context("with test files", func() {
 		It("iso created successfully", func() {
 			editor := NewEditor(workDir)
 
-			err := editor.CreateMinimalISOTemplate(isoFile, testRootFSURL, "x86_64", minimalISOPath)
+			err := editor.CreateMinimalISOTemplate(context.Background(), isoFile, testRootFSURL, "x86_64", minimalISOPath)
 			Expect(err).ToNot(HaveOccurred())
 		})
 
 		It("missing iso file", func() {
 			editor := NewEditor(workDir)
-			err := editor.CreateMinimalISOTemplate("invalid", testRootFSURL, "x86_64", minimalISOPath)
+			err := editor.CreateMinimalISOTemplate(context.Background(), "invalid", testRootFSURL, "x86_64", minimalISOPath)
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("fails early when the source is a minimal iso", func() {
+			editor := NewEditor(workDir)
+			Expect(editor.CreateMinimalISOTemplate(context.Background(), isoFile, testRootFSURL, "x86_64", minimalISOPath)).To(Succeed())
+
+			events := make(chan BuildEvent, 100)
+			editor = NewEditor(workDir, WithBuildEvents(events))
+			err := editor.CreateMinimalISOTemplate(context.Background(), minimalISOPath, testRootFSURL, "x86_64", filepath.Join(workDir, "minimal2.iso"))
+			Expect(err).To(MatchError(ContainSubstring("source is already a minimal ISO")))
+			close(events)
+
+			for event := range events {
+				Expect(event.Stage).To(Equal(StageExtract))
+			}
+		})
+
+		It("fails early when the source is missing boot files", func() {
+			isoPath := filepath.Join(workDir, "novmlinuz.iso")
+			Expect(os.Remove(filepath.Join(filesDir, "images/pxeboot/vmlinuz"))).To(Succeed())
+			Expect(Create(isoPath, filesDir, volumeID)).To(Succeed())
+
+			editor := NewEditor(workDir)
+			err := editor.CreateMinimalISOTemplate(context.Background(), isoPath, testRootFSURL, "x86_64", minimalISOPath)
+			Expect(err).To(MatchError(ContainSubstring("missing required boot files: images/pxeboot/vmlinuz")))
+
+			exists, err := fileExists(minimalISOPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(exists).To(BeFalse())
+		})
+
+		It("reports the missing boot files of a source without the pxeboot directory", func() {
+			isoPath := filepath.Join(workDir, "nopxeboot.iso")
+			Expect(os.RemoveAll(filepath.Join(filesDir, "images/pxeboot"))).To(Succeed())
+			Expect(Create(isoPath, filesDir, volumeID)).To(Succeed())
+
+			editor := NewEditor(workDir)
+			err := editor.CreateMinimalISOTemplate(context.Background(), isoPath, testRootFSURL, "x86_64", minimalISOPath)
+			Expect(err).To(MatchError(ContainSubstring("missing required boot files: images/pxeboot/vmlinuz, " + rootFSPathInISO)))
+		})
+
+		It("validates the source iso when asked", func() {
+			editor := NewEditor(workDir, WithISOValidation())
+			err := editor.CreateMinimalISOTemplate(context.Background(), isoFile, testRootFSURL, "x86_64", minimalISOPath)
+			Expect(err).ToNot(HaveOccurred())
+
+			// a generic install ISO booting through isolinux only
+			isoPath := filepath.Join(workDir, "generic.iso")
+			Expect(os.Remove(filepath.Join(filesDir, "EFI/redhat/grub.cfg"))).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(filesDir, "isolinux/isolinux.cfg"), []byte("label linux\n  append initrd=/images/pxeboot/initrd.img quiet\n"), 0600)).To(Succeed())
+			Expect(Create(isoPath, filesDir, volumeID)).To(Succeed())
+			err = editor.CreateMinimalISOTemplate(context.Background(), isoPath, testRootFSURL, "x86_64", filepath.Join(workDir, "minimal2.iso"))
+			var invalidErr *ErrInvalidISO
+			Expect(errors.As(err, &invalidErr)).To(BeTrue())
+			Expect(invalidErr.Missing).To(Equal([]string{"grub.cfg", "coreos.liveiso kernel argument"}))
+		})
+
+		It("sends build events", func() {
+			events := make(chan BuildEvent, 100)
+			editor := NewEditor(workDir, WithBuildEvents(events))
+
+			err := editor.CreateMinimalISOTemplate(context.Background(), isoFile, testRootFSURL, "x86_64", minimalISOPath)
+			Expect(err).ToNot(HaveOccurred())
+			close(events)
+
+			var received []BuildEvent
+			for event := range events {
+				received = append(received, event)
+			}
+			Expect(received).ToNot(BeEmpty())
+			Expect(received[0]).To(Equal(BuildEvent{Type: BuildEventStageStarted, Stage: StageExtract}))
+			Expect(received).To(ContainElement(BuildEvent{Type: BuildEventStageFinished, Stage: StageGrubConfig}))
+			Expect(received).To(ContainElement(BuildEvent{Type: BuildEventStageFinished, Stage: StageCreate}))
+			Expect(received[len(received)-1]).To(Equal(BuildEvent{
+				Type:       BuildEventCompleted,
+				Path:       minimalISOPath,
+				GrubConfig: "EFI/redhat/grub.cfg",
+				Isolinux:   true,
+			}))
+		})
+
+		It("returns the edited boot configs in the build result", func() {
+			var result BuildResult
+			editor := NewEditor(workDir, WithBuildResult(&result))
+
+			err := editor.CreateMinimalISOTemplate(context.Background(), isoFile, testRootFSURL, "x86_64", minimalISOPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(result).To(Equal(BuildResult{Path: minimalISOPath, GrubConfig: "EFI/redhat/grub.cfg", Isolinux: true}))
+		})
+
+		It("reports the edited boot configs for ppc64le", func() {
+			var result BuildResult
+			events := make(chan BuildEvent, 100)
+			editor := NewEditor(workDir, WithBuildEvents(events), WithBuildResult(&result))
+
+			err := editor.CreateMinimalISOTemplate(context.Background(), isoFile, testRootFSURL, "ppc64le", minimalISOPath)
+			Expect(err).ToNot(HaveOccurred())
+			close(events)
+
+			var completed BuildEvent
+			for event := range events {
+				completed = event
+			}
+			Expect(completed.Type).To(Equal(BuildEventCompleted))
+			Expect(completed.GrubConfig).To(Equal("EFI/redhat/grub.cfg"))
+			Expect(completed.Isolinux).To(BeFalse())
+			Expect(result.GrubConfig).To(Equal("EFI/redhat/grub.cfg"))
+			Expect(result.Isolinux).To(BeFalse())
+		})
+
+		It("detects the arch when it is not given", func() {
+			events := make(chan BuildEvent, 100)
+			editor := NewEditor(workDir, WithBuildEvents(events))
+
+			err := editor.CreateMinimalISOTemplate(context.Background(), isoFile, testRootFSURL, "", minimalISOPath)
+			Expect(err).ToNot(HaveOccurred())
+			close(events)
+
+			var received []BuildEvent
+			for event := range events {
+				received = append(received, event)
+			}
+			Expect(received).To(ContainElement(BuildEvent{Type: BuildEventStageFinished, Stage: StageDetectArch}))
+			Expect(received).To(ContainElement(BuildEvent{Type: BuildEventStageFinished, Stage: StageIsolinuxConfig}))
+			Expect(received[len(received)-1].Isolinux).To(BeTrue())
+		})
+
+		It("skips the isolinux config for a detected ppc64le iso", func() {
+			isoPath := filepath.Join(workDir, "ppc64le.iso")
+			Expect(os.MkdirAll(filepath.Join(filesDir, "ppc"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(filesDir, "ppc/bootinfo.txt"), []byte("bootinfo"), 0600)).To(Succeed())
+			Expect(Create(isoPath, filesDir, volumeID)).To(Succeed())
+
+			events := make(chan BuildEvent, 100)
+			editor := NewEditor(workDir, WithBuildEvents(events))
+			err := editor.CreateMinimalISOTemplate(context.Background(), isoPath, testRootFSURL, "", minimalISOPath)
+			Expect(err).ToNot(HaveOccurred())
+			close(events)
+
+			var received []BuildEvent
+			for event := range events {
+				Expect(event.Stage).NotTo(Equal(StageIsolinuxConfig))
+				received = append(received, event)
+			}
+			Expect(received[len(received)-1].Isolinux).To(BeFalse())
+
+			isolinuxCfg, err := ReadFileFromISO(minimalISOPath, "/isolinux/isolinux.cfg")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(isolinuxCfg)).To(Equal(testISOLinuxConfig))
+		})
+
+		It("reports the failed stage in build events", func() {
+			events := make(chan BuildEvent, 100)
+			editor := NewEditor(workDir, WithBuildEvents(events))
+
+			err := editor.CreateMinimalISOTemplate(context.Background(), "invalid", testRootFSURL, "x86_64", minimalISOPath)
 			Expect(err).To(HaveOccurred())
+			close(events)
+
+			var received []BuildEvent
+			for event := range events {
+				received = append(received, event)
+			}
+			Expect(received).To(HaveLen(2))
+			Expect(received[1].Type).To(Equal(BuildEventStageFinished))
+			Expect(received[1].Stage).To(Equal(StageExtract))
+			Expect(received[1].Err).To(HaveOccurred())
+		})
+
+		It("stops a canceled build and removes its work files", func() {
+			// canceled once the extract stage has started
+			ctx := &countdownContext{Context: context.Background(), remaining: 1}
+
+			editor := NewEditor(workDir)
+			err := editor.CreateMinimalISOTemplate(ctx, isoFile, testRootFSURL, "x86_64", minimalISOPath)
+			Expect(err).To(MatchError(context.Canceled))
+
+			exists, err := fileExists(minimalISOPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(exists).To(BeFalse())
+			entries, err := os.ReadDir(workDir)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entries).To(BeEmpty())
+		})
+
+		It("extracts the iso in the temp dir", func() {
+			editor := NewEditor(workDir, WithTempDir(filepath.Join(workDir, "missing")))
+			err := editor.CreateMinimalISOTemplate(context.Background(), isoFile, testRootFSURL, "x86_64", minimalISOPath)
+			Expect(err).To(MatchError(ContainSubstring(filepath.Join(workDir, "missing"))))
+
+			tempDir := filepath.Join(workDir, "tmp")
+			Expect(os.Mkdir(tempDir, 0755)).To(Succeed())
+			editor = NewEditor(workDir, WithTempDir(tempDir))
+			Expect(editor.CreateMinimalISOTemplate(context.Background(), isoFile, testRootFSURL, "x86_64", minimalISOPath)).To(Succeed())
+			entries, err := os.ReadDir(tempDir)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entries).To(BeEmpty())
+		})
+
+		It("does not start a build with a done context", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+
+			editor := NewEditor(workDir)
+			err := editor.CreateMinimalISOTemplate(ctx, isoFile, testRootFSURL, "x86_64", minimalISOPath)
+			Expect(err).To(MatchError(context.Canceled))
+		})
+
+		It("stops a build once its timeout elapsed", func() {
+			editor := NewEditor(workDir, WithBuildTimeout(time.Nanosecond))
+			err := editor.CreateMinimalISOTemplate(context.Background(), isoFile, testRootFSURL, "x86_64", minimalISOPath)
+			Expect(err).To(MatchError(context.DeadlineExceeded))
+			Expect(minimalISOPath).ToNot(BeAnExistingFile())
+
+			err = CreateMinimalISO(filesDir, volumeID, testRootFSURL, "x86_64", minimalISOPath, WithBuildTimeout(time.Nanosecond))
+			Expect(err).To(MatchError(context.DeadlineExceeded))
+		})
+
+		It("stops extracting and creating the iso once the context is done", func() {
+			for _, stage := range []string{StageExtract, StageCreate} {
+				ctx, cancel := context.WithCancel(context.Background())
+				cancelOnStart := func(started string, _ float64, _ error) {
+					if started == stage {
+						cancel()
+					}
+				}
+
+				editor := NewEditor(workDir, WithProgress(cancelOnStart))
+				err := editor.CreateMinimalISOTemplate(ctx, isoFile, testRootFSURL, "x86_64", minimalISOPath)
+				Expect(err).To(MatchError(ContainSubstring(context.Canceled.Error())), stage)
+				Expect(minimalISOPath).ToNot(BeAnExistingFile())
+			}
+		})
+
+		It("fails a build canceled while the iso is finalized", func() {
+			// count the checks of the context made while the iso is written next to its final path
+			tmpPath := tempISOPath(minimalISOPath)
+			ctx := &fileContext{Context: context.Background(), path: tmpPath}
+			Expect(NewEditor(workDir).CreateMinimalISOTemplate(ctx, isoFile, testRootFSURL, "x86_64", minimalISOPath)).To(Succeed())
+			Expect(ctx.calls).To(BeNumerically(">", 2))
+			Expect(os.Remove(minimalISOPath)).To(Succeed())
+
+			// cancel the build on the last write of the iso, whose error diskfs ignores like the ones of the
+			// directory records and volume descriptors written before it
+			ctx = &fileContext{Context: context.Background(), path: tmpPath, cancelAt: ctx.calls - 1}
+			err := NewEditor(workDir).CreateMinimalISOTemplate(ctx, isoFile, testRootFSURL, "x86_64", minimalISOPath)
+			Expect(err).To(MatchError(context.Canceled))
+			Expect(minimalISOPath).ToNot(BeAnExistingFile())
+			Expect(tmpPath).ToNot(BeAnExistingFile())
+		})
+
+		It("does not stop a build within its timeout", func() {
+			editor := NewEditor(workDir, WithBuildTimeout(time.Hour))
+			err := editor.CreateMinimalISOTemplate(context.Background(), isoFile, testRootFSURL, "x86_64", minimalISOPath)
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("does not block on a consumer that is not receiving", func() {
+			events := make(chan BuildEvent)
+			editor := NewEditor(workDir, WithBuildEvents(events))
+
+			err := editor.CreateMinimalISOTemplate(context.Background(), isoFile, testRootFSURL, "x86_64", minimalISOPath)
+			Expect(err).ToNot(HaveOccurred())
 		})
 	})
 
@@ -72,35 +399,1456 @@ var _ = Context("with test files", func() {
 		It("iso created successfully", func() {
 			editor := NewEditor(workDir)
 
-			err := editor.CreateMinimalISOTemplate(isoFile, testFCOSRootFSURL, "x86_64", minimalISOPath)
+			err := editor.CreateMinimalISOTemplate(context.Background(), isoFile, testFCOSRootFSURL, "x86_64", minimalISOPath)
 			Expect(err).ToNot(HaveOccurred())
 		})
 
 		It("missing iso file", func() {
 			editor := NewEditor(workDir)
-			err := editor.CreateMinimalISOTemplate("invalid", testFCOSRootFSURL, "x86_64", minimalISOPath)
+			err := editor.CreateMinimalISOTemplate(context.Background(), "invalid", testFCOSRootFSURL, "x86_64", minimalISOPath)
 			Expect(err).To(HaveOccurred())
 		})
 	})
-	It("fixGrubConfig alters the kernel parameters correctly", func() {
-		err := fixGrubConfig(testRootFSURL, filesDir)
-		Expect(err).ToNot(HaveOccurred())
 
-		newLine := "	linux /images/pxeboot/vmlinuz random.trust_cpu=on rd.luks.options=discard ignition.firstboot ignition.platform.id=metal 'coreos.live.rootfs_url=%s'"
-		grubCfg := fmt.Sprintf(newLine, testRootFSURL)
-		validateFileContainsLine(filepath.Join(filesDir, "EFI/redhat/grub.cfg"), grubCfg)
+	Describe("temporary directories", func() {
+		extractDirs := func() []string {
+			dirs, err := filepath.Glob(filepath.Join(workDir, "isoutil*"))
+			Expect(err).ToNot(HaveOccurred())
+			return dirs
+		}
 
-		newLine = "	initrd /images/pxeboot/initrd.img /images/ignition.img %s"
-		grubCfg = fmt.Sprintf(newLine, ramDiskImagePath)
-		validateFileContainsLine(filepath.Join(filesDir, "EFI/redhat/grub.cfg"), grubCfg)
+		It("removes the extract dir after a successful build", func() {
+			editor := NewEditor(workDir)
+			Expect(editor.CreateMinimalISOTemplate(context.Background(), isoFile, testRootFSURL, "x86_64", minimalISOPath)).To(Succeed())
+			Expect(extractDirs()).To(BeEmpty())
+			Expect(editor.Close()).To(Succeed())
+		})
+
+		It("removes the extract dir after a failed build", func() {
+			editor := NewEditor(workDir)
+			err := editor.CreateMinimalISOTemplate(context.Background(), filepath.Join(workDir, "missing.iso"), testRootFSURL, "x86_64", minimalISOPath)
+			Expect(err).To(HaveOccurred())
+			Expect(extractDirs()).To(BeEmpty())
+		})
+
+		It("removes the directories left behind on close", func() {
+			editor := NewEditor(workDir).(*rhcosEditor)
+			dir, err := editor.mkdirTemp()
+			Expect(err).ToNot(HaveOccurred())
+			Expect(os.WriteFile(filepath.Join(dir, "leftover"), []byte("leftover"), 0600)).To(Succeed())
 
+			Expect(editor.Close()).To(Succeed())
+			Expect(extractDirs()).To(BeEmpty())
+		})
 	})
-	It("fixIsolinuxConfig alters the kernel parameters correctly", func() {
-		err := fixIsolinuxConfig(testRootFSURL, filesDir)
-		Expect(err).ToNot(HaveOccurred())
 
-		newLine := "  append initrd=/images/pxeboot/initrd.img,/images/ignition.img,%s random.trust_cpu=on rd.luks.options=discard ignition.firstboot ignition.platform.id=metal coreos.live.rootfs_url=%s"
-		isolinuxCfg := fmt.Sprintf(newLine, ramDiskImagePath, testRootFSURL)
-		validateFileContainsLine(filepath.Join(filesDir, "isolinux/isolinux.cfg"), isolinuxCfg)
+	Describe("temporary minimal iso", func() {
+		It("is created next to the output instead of in the work dir", func() {
+			outputDir, err := os.MkdirTemp("", "testisooutput")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(outputDir)
+			outputPath := filepath.Join(outputDir, "minimal.iso")
+			Expect(filepath.Dir(tempISOPath(outputPath))).To(Equal(outputDir))
+
+			// leftover from an interrupted build
+			Expect(os.WriteFile(tempISOPath(outputPath), []byte("partial"), 0600)).To(Succeed())
+
+			editor := NewEditor(workDir)
+			Expect(editor.CreateMinimalISOTemplate(context.Background(), isoFile, testRootFSURL, "x86_64", outputPath)).To(Succeed())
+
+			rootFSURL, err := GetRootFSURL(outputPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rootFSURL).To(Equal(testRootFSURL))
+			exists, err := fileExists(tempISOPath(outputPath))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(exists).To(BeFalse())
+			entries, err := os.ReadDir(workDir)
+			Expect(err).NotTo(HaveOccurred())
+			for _, entry := range entries {
+				Expect(entry.Name()).NotTo(HaveSuffix(".tmp"))
+			}
+		})
 	})
-})
+
+	Describe("volume identifier check", func() {
+		It("keeps the volume identifier of the source iso", func() {
+			editor := NewEditor(workDir, WithVolumeIDCheck())
+			Expect(editor.CreateMinimalISOTemplate(context.Background(), isoFile, testRootFSURL, "x86_64", minimalISOPath)).To(Succeed())
+
+			sourceVolumeID, err := VolumeIdentifier(isoFile)
+			Expect(err).ToNot(HaveOccurred())
+			minimalVolumeID, err := VolumeIdentifier(minimalISOPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(minimalVolumeID).To(Equal(sourceVolumeID))
+		})
+	})
+
+	Describe("volume identifier override", func() {
+		It("keeps an identifier of the maximum length", func() {
+			id := strings.Repeat("a", MaxVolumeIDLength)
+			resolved, warnings, err := newMinimalISOOptions(WithVolumeID(id)).resolveVolumeID(volumeID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resolved).To(Equal(id))
+			Expect(warnings).To(BeEmpty())
+		})
+
+		It("truncates a longer identifier with a warning", func() {
+			id := strings.Repeat("a", MaxVolumeIDLength) + "b"
+			resolved, warnings, err := newMinimalISOOptions(WithVolumeID(id)).resolveVolumeID(volumeID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resolved).To(Equal(strings.Repeat("a", MaxVolumeIDLength)))
+			Expect(warnings).To(HaveLen(1))
+			Expect(warnings[0].Kind).To(Equal(BuildWarningVolumeIDTruncated))
+		})
+
+		It("uses the identifier of the source iso without an override", func() {
+			resolved, warnings, err := newMinimalISOOptions().resolveVolumeID(volumeID)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(resolved).To(Equal(volumeID))
+			Expect(warnings).To(BeEmpty())
+		})
+
+		It("fails for an identifier with illegal characters", func() {
+			err := CreateMinimalISO(filesDir, volumeID, testRootFSURL, "x86_64", minimalISOPath, WithVolumeID("my iso/1"))
+			Expect(err).To(MatchError(ContainSubstring(`illegal character ' '`)))
+			Expect(minimalISOPath).ToNot(BeAnExistingFile())
+		})
+
+		It("sets the identifier of the minimal iso", func() {
+			editor := NewEditor(workDir, WithVolumeID("custom-id"), WithVolumeIDCheck())
+			Expect(editor.CreateMinimalISOTemplate(context.Background(), isoFile, testRootFSURL, "x86_64", minimalISOPath)).To(Succeed())
+
+			minimalVolumeID, err := VolumeIdentifier(minimalISOPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(minimalVolumeID).To(Equal("custom-id"))
+		})
+	})
+
+	Describe("progress", func() {
+		It("reports the fraction of the build done as each stage starts and completes", func() {
+			var progress []string
+			var fractions []float64
+			editor := NewEditor(workDir, WithProgress(func(stage string, fraction float64, err error) {
+				Expect(err).ToNot(HaveOccurred())
+				progress = append(progress, fmt.Sprintf("%s:%v", stage, fraction))
+				fractions = append(fractions, fraction)
+			}))
+			Expect(editor.CreateMinimalISOTemplate(context.Background(), isoFile, testRootFSURL, "x86_64", minimalISOPath)).To(Succeed())
+
+			Expect(progress).To(HaveLen(15))
+			Expect(progress[:2]).To(Equal([]string{StageExtract + ":0", StageExtract + ":0.4"}))
+			Expect(progress[12]).To(HavePrefix(StageCreate + ":"))
+			Expect(progress[14]).To(Equal(":1"))
+			// the extraction and the creation of the iso take most of the build
+			Expect(fractions[13] - fractions[12]).To(BeNumerically("~", 0.4))
+			Expect(sort.Float64sAreSorted(fractions)).To(BeTrue())
+		})
+
+		It("reports the stage that failed", func() {
+			var failedStage string
+			var failedErr error
+			editor := NewEditor(workDir, WithProgress(func(stage string, _ float64, err error) {
+				if err != nil {
+					failedStage, failedErr = stage, err
+				}
+			}))
+			err := editor.CreateMinimalISOTemplate(context.Background(), "invalid", testRootFSURL, "x86_64", minimalISOPath)
+			Expect(err).To(HaveOccurred())
+			Expect(failedStage).To(Equal(StageExtract))
+			Expect(failedErr).To(Equal(err))
+		})
+
+		It("tolerates a nil function", func() {
+			Expect(CreateMinimalISO(filesDir, volumeID, testRootFSURL, "x86_64", minimalISOPath, WithProgress(nil))).To(Succeed())
+		})
+	})
+
+	Describe("build report", func() {
+		It("summarizes the build", func() {
+			editor := NewEditor(workDir, WithBuildReport())
+			Expect(editor.CreateMinimalISOTemplate(context.Background(), isoFile, testRootFSURL, "x86_64", minimalISOPath)).To(Succeed())
+
+			report, err := ReadBuildReport(BuildReportPath(minimalISOPath))
+			Expect(err).ToNot(HaveOccurred())
+			info, err := os.Stat(minimalISOPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(report.VolumeID).To(Equal(volumeID))
+			Expect(report.RootFSURL).To(Equal(testRootFSURL))
+			Expect(report.Arch).To(Equal("x86_64"))
+			Expect(report.Path).To(Equal(minimalISOPath))
+			Expect(report.Size).To(Equal(info.Size()))
+			Expect(report.GrubConfig).To(Equal("EFI/redhat/grub.cfg"))
+			Expect(report.Isolinux).To(BeTrue())
+			Expect(report.Warnings).To(BeEmpty())
+
+			var stages []string
+			for _, timing := range report.Stages {
+				Expect(timing.Seconds).To(BeNumerically(">=", 0))
+				stages = append(stages, timing.Stage)
+			}
+			Expect(stages).To(Equal([]string{StageExtract, StageVolumeIdentifier, StageRemoveRootFS, StageEmbedPlaceholders,
+				StageGrubConfig, StageIsolinuxConfig, StageCreate}))
+		})
+
+		It("reports the warnings", func() {
+			Expect(os.Remove(filepath.Join(filesDir, "isolinux/isolinux.cfg"))).To(Succeed())
+			Expect(CreateMinimalISO(filesDir, volumeID, testRootFSURL, "x86_64", minimalISOPath, WithBuildReport())).To(Succeed())
+
+			report, err := ReadBuildReport(BuildReportPath(minimalISOPath))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(report.Isolinux).To(BeFalse())
+			Expect(report.Warnings).To(HaveLen(1))
+			Expect(report.Warnings[0].Kind).To(Equal(BuildWarningMissingConfig))
+		})
+	})
+
+	Describe("post-processors", func() {
+		It("runs them in order on the minimal iso", func() {
+			var calls []string
+			record := func(name string) PostProcessor {
+				return func(isoPath string) error {
+					Expect(isoPath).To(BeAnExistingFile())
+					calls = append(calls, name+":"+isoPath)
+					return nil
+				}
+			}
+
+			editor := NewEditor(workDir, WithPostProcessors(record("sign"), record("upload")))
+			Expect(editor.CreateMinimalISOTemplate(context.Background(), isoFile, testRootFSURL, "x86_64", minimalISOPath)).To(Succeed())
+			Expect(calls).To(Equal([]string{"sign:" + minimalISOPath, "upload:" + minimalISOPath}))
+		})
+
+		It("stops at the first failure", func() {
+			called := false
+			failing := func(string) error { return errors.New("signing failed") }
+			next := func(string) error {
+				called = true
+				return nil
+			}
+
+			err := CreateMinimalISO(filesDir, volumeID, testRootFSURL, "x86_64", minimalISOPath, WithPostProcessors(failing, next))
+			Expect(err).To(MatchError(ContainSubstring("post-processor 0 failed")))
+			Expect(errors.Unwrap(err)).To(MatchError("signing failed"))
+			Expect(called).To(BeFalse())
+		})
+	})
+
+	Describe("CreateMinimalISOTemplateFromReader", func() {
+		It("creates the minimal iso from a reader of the full iso", func() {
+			f, err := os.Open(isoFile)
+			Expect(err).ToNot(HaveOccurred())
+			defer f.Close()
+			info, err := f.Stat()
+			Expect(err).ToNot(HaveOccurred())
+
+			editor := NewEditor(workDir)
+			Expect(editor.CreateMinimalISOTemplateFromReader(context.Background(), f, info.Size(), testRootFSURL, "x86_64", minimalISOPath)).To(Succeed())
+
+			rootFSURL, err := GetRootFSURL(minimalISOPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rootFSURL).To(Equal(testRootFSURL))
+			// only the minimal iso is left in the work dir
+			entries, err := os.ReadDir(workDir)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entries).To(HaveLen(1))
+		})
+
+		It("stops copying the full iso once the build timeout elapsed", func() {
+			f, err := os.Open(isoFile)
+			Expect(err).ToNot(HaveOccurred())
+			defer f.Close()
+			info, err := f.Stat()
+			Expect(err).ToNot(HaveOccurred())
+
+			r := &slowReaderAt{ReaderAt: f, delay: 50 * time.Millisecond}
+			editor := NewEditor(workDir, WithBuildTimeout(10*time.Millisecond))
+			err = editor.CreateMinimalISOTemplateFromReader(context.Background(), r, info.Size(), testRootFSURL, "x86_64", minimalISOPath)
+			Expect(err).To(MatchError(ContainSubstring("failed to copy the full ISO")))
+			Expect(errors.Is(err, context.DeadlineExceeded)).To(BeTrue())
+			Expect(r.reads).To(Equal(1))
+			entries, err := os.ReadDir(workDir)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entries).To(BeEmpty())
+		})
+	})
+
+	Describe("CreateMinimalISOFromDir", func() {
+		It("creates the minimal iso from the extracted tree", func() {
+			Expect(CreateMinimalISOFromDir(filesDir, volumeID, testRootFSURL, "", minimalISOPath)).To(Succeed())
+
+			rootFSURL, err := GetRootFSURL(minimalISOPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rootFSURL).To(Equal(testRootFSURL))
+		})
+
+		It("leaves the extracted tree as is", func() {
+			tempDir := filepath.Join(workDir, "tmp")
+			Expect(os.Mkdir(tempDir, 0755)).To(Succeed())
+			Expect(CreateMinimalISOFromDir(filesDir, volumeID, testRootFSURL, "x86_64", minimalISOPath, WithTempDir(tempDir))).To(Succeed())
+
+			Expect(filesDir).To(BeADirectory())
+			for path, content := range map[string]string{
+				"EFI/redhat/grub.cfg":       testGrubConfig,
+				"isolinux/isolinux.cfg":     testISOLinuxConfig,
+				"images/pxeboot/rootfs.img": "this is rootfs",
+			} {
+				fileContent, err := os.ReadFile(filepath.Join(filesDir, path))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(fileContent)).To(Equal(content))
+			}
+			placeholder, err := os.ReadFile(filepath.Join(filesDir, ramDiskImagePath))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(placeholder).To(Equal(make([]byte, RamDiskPaddingLength)))
+			entries, err := os.ReadDir(tempDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries).To(BeEmpty())
+
+			// the tree can be used again for another minimal iso
+			otherISOPath := filepath.Join(workDir, "other.iso")
+			Expect(CreateMinimalISOFromDir(filesDir, volumeID, testFCOSRootFSURL, "x86_64", otherISOPath)).To(Succeed())
+			grubCfg, err := ReadFileFromISO(otherISOPath, "/EFI/redhat/grub.cfg")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(grubCfg)).To(ContainSubstring(testFCOSRootFSURL))
+			Expect(string(grubCfg)).NotTo(ContainSubstring(testRootFSURL))
+		})
+
+		It("fails with an invalid rootfs url", func() {
+			err := CreateMinimalISOFromDir(filesDir, volumeID, "ftp://example.com/rootfs.img", "x86_64", minimalISOPath)
+			Expect(err).To(HaveOccurred())
+			Expect(minimalISOPath).ToNot(BeAnExistingFile())
+		})
+	})
+
+	Describe("CreateMinimalISOReader", func() {
+		It("streams the minimal iso and removes it once closed", func() {
+			r, err := CreateMinimalISOReader(filesDir, volumeID, testRootFSURL, "x86_64")
+			Expect(err).ToNot(HaveOccurred())
+			tempDir := r.(*tempISOReader).dir
+
+			out, err := os.Create(minimalISOPath)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = io.Copy(out, r)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(out.Close()).To(Succeed())
+			Expect(r.Close()).To(Succeed())
+			Expect(tempDir).ToNot(BeAnExistingFile())
+
+			rootFSURL, err := GetRootFSURL(minimalISOPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(rootFSURL).To(Equal(testRootFSURL))
+		})
+
+		It("leaves the extracted tree as is", func() {
+			r, err := CreateMinimalISOReader(filesDir, volumeID, testRootFSURL, "x86_64")
+			Expect(err).ToNot(HaveOccurred())
+			Expect(r.Close()).To(Succeed())
+
+			for path, content := range map[string]string{
+				"EFI/redhat/grub.cfg":       testGrubConfig,
+				"isolinux/isolinux.cfg":     testISOLinuxConfig,
+				"images/pxeboot/rootfs.img": "this is rootfs",
+			} {
+				fileContent, err := os.ReadFile(filepath.Join(filesDir, path))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(fileContent)).To(Equal(content))
+			}
+		})
+
+		It("builds the minimal iso in the temp dir", func() {
+			tempDir := filepath.Join(workDir, "tmp")
+			Expect(os.Mkdir(tempDir, 0755)).To(Succeed())
+
+			r, err := CreateMinimalISOReader(filesDir, volumeID, testRootFSURL, "x86_64", WithTempDir(tempDir))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(filepath.Dir(r.(*tempISOReader).dir)).To(Equal(tempDir))
+			Expect(r.Close()).To(Succeed())
+			entries, err := os.ReadDir(tempDir)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entries).To(BeEmpty())
+		})
+
+		It("fails with an invalid rootfs url", func() {
+			r, err := CreateMinimalISOReader(filesDir, volumeID, "ftp://example.com/rootfs.img", "x86_64")
+			Expect(err).To(HaveOccurred())
+			Expect(r).To(BeNil())
+		})
+	})
+
+	Describe("CreateMinimalISOs", func() {
+		It("creates a minimal iso per rootfs url", func() {
+			otherISOPath := filepath.Join(workDir, "minimal-fcos.iso")
+			rootFSURLs := map[string]string{
+				minimalISOPath: testRootFSURL,
+				otherISOPath:   testFCOSRootFSURL,
+				filepath.Join(workDir, "minimal-third.iso"): testRootFSURL + "?third",
+			}
+			results, err := CreateMinimalISOs(isoFile, "x86_64", rootFSURLs, WithTempDir(workDir), WithChecksum())
+			Expect(err).NotTo(HaveOccurred())
+			Expect(results).To(HaveLen(len(rootFSURLs)))
+
+			for isoPath, url := range rootFSURLs {
+				Expect(results[isoPath].Path).To(Equal(isoPath))
+				checksum, err := FileChecksum(isoPath, DefaultChecksumAlgorithm)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(results[isoPath].Checksum).To(Equal(checksum))
+
+				rootFSURL, err := GetRootFSURL(isoPath)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(rootFSURL).To(Equal(url))
+
+				for _, cfg := range []string{"/EFI/redhat/grub.cfg", "/isolinux/isolinux.cfg"} {
+					content, err := ReadFileFromISO(isoPath, cfg)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(strings.Count(string(content), DefaultRootFSArgKey)).To(Equal(1))
+					Expect(strings.Count(string(content), ramDiskImagePath)).To(Equal(1))
+				}
+			}
+		})
+
+		It("detects the arch when it is not given", func() {
+			_, err := CreateMinimalISOs(isoFile, "", map[string]string{minimalISOPath: testRootFSURL}, WithBuildReport())
+			Expect(err).NotTo(HaveOccurred())
+
+			report, err := ReadBuildReport(BuildReportPath(minimalISOPath))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(report.Arch).To(Equal("x86_64"))
+			Expect(report.Isolinux).To(BeTrue())
+		})
+
+		It("removes its temporary directories", func() {
+			tempDir, err := os.MkdirTemp(workDir, "temp")
+			Expect(err).NotTo(HaveOccurred())
+			rootFSURLs := map[string]string{
+				minimalISOPath:                      testRootFSURL,
+				filepath.Join(workDir, "other.iso"): testFCOSRootFSURL,
+			}
+			_, err = CreateMinimalISOs(isoFile, "x86_64", rootFSURLs, WithTempDir(tempDir))
+			Expect(err).NotTo(HaveOccurred())
+
+			entries, err := os.ReadDir(tempDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries).To(BeEmpty())
+		})
+
+		It("fails early when the source is a minimal iso", func() {
+			_, err := CreateMinimalISOs(isoFile, "x86_64", map[string]string{minimalISOPath: testRootFSURL})
+			Expect(err).NotTo(HaveOccurred())
+
+			otherISOPath := filepath.Join(workDir, "other.iso")
+			_, err = CreateMinimalISOs(minimalISOPath, "x86_64", map[string]string{otherISOPath: testRootFSURL})
+			Expect(err).To(MatchError(ContainSubstring("source is already a minimal ISO")))
+			Expect(otherISOPath).NotTo(BeAnExistingFile())
+		})
+
+		It("fails with a missing iso file", func() {
+			_, err := CreateMinimalISOs("invalid", "x86_64", map[string]string{minimalISOPath: testRootFSURL})
+			Expect(err).To(HaveOccurred())
+		})
+
+		It("rejects the options holding a single build", func() {
+			for _, opt := range []MinimalISOOption{WithBuildResult(&BuildResult{}), WithDryRun(&DryRunReport{})} {
+				_, err := CreateMinimalISOs(isoFile, "x86_64", map[string]string{minimalISOPath: testRootFSURL}, opt)
+				Expect(err).To(MatchError(ContainSubstring("can't be used with several minimal ISOs")))
+				Expect(minimalISOPath).NotTo(BeAnExistingFile())
+			}
+		})
+	})
+	It("fixGrubConfig alters the kernel parameters correctly", func() {
+		warnings, err := fixGrubConfig(testRootFSURL, filesDir, newMinimalISOOptions())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(warnings).To(BeEmpty())
+
+		newLine := "	linux /images/pxeboot/vmlinuz random.trust_cpu=on rd.luks.options=discard ignition.firstboot ignition.platform.id=metal 'coreos.live.rootfs_url=%s'"
+		grubCfg := fmt.Sprintf(newLine, testRootFSURL)
+		validateFileContainsLine(filepath.Join(filesDir, "EFI/redhat/grub.cfg"), grubCfg)
+
+		newLine = "	initrd /images/pxeboot/initrd.img /images/ignition.img %s"
+		grubCfg = fmt.Sprintf(newLine, ramDiskImagePath)
+		validateFileContainsLine(filepath.Join(filesDir, "EFI/redhat/grub.cfg"), grubCfg)
+
+	})
+	It("fixIsolinuxConfig alters the kernel parameters correctly", func() {
+		warnings, err := fixIsolinuxConfig(testRootFSURL, filesDir, newMinimalISOOptions())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(warnings).To(BeEmpty())
+
+		newLine := "  append initrd=/images/pxeboot/initrd.img,/images/ignition.img,%s random.trust_cpu=on rd.luks.options=discard ignition.firstboot ignition.platform.id=metal coreos.live.rootfs_url=%s"
+		isolinuxCfg := fmt.Sprintf(newLine, ramDiskImagePath, testRootFSURL)
+		validateFileContainsLine(filepath.Join(filesDir, "isolinux/isolinux.cfg"), isolinuxCfg)
+	})
+
+	Describe("missing boot configs", func() {
+		It("fails without any boot config to edit", func() {
+			for _, path := range []string{"EFI/redhat/grub.cfg", "isolinux/isolinux.cfg"} {
+				Expect(os.Remove(filepath.Join(filesDir, path))).To(Succeed())
+			}
+
+			err := CreateMinimalISO(filesDir, volumeID, testRootFSURL, "x86_64", minimalISOPath)
+			var noConfigErr *ErrNoBootConfig
+			Expect(errors.As(err, &noConfigErr)).To(BeTrue())
+			Expect(noConfigErr.Path).To(Equal(filesDir))
+			Expect(noConfigErr.Paths).To(ContainElements("EFI/redhat/grub.cfg", "isolinux/isolinux.cfg", "images/*.prm"))
+			Expect(minimalISOPath).ToNot(BeAnExistingFile())
+			Expect(filepath.Join(filesDir, "images/pxeboot/rootfs.img")).To(BeAnExistingFile())
+		})
+	})
+
+	Describe("rootfs removal", func() {
+		It("removes a read-only rootfs", func() {
+			rootFSDir := filepath.Join(filesDir, "images/pxeboot")
+			Expect(os.Chmod(filepath.Join(rootFSDir, "rootfs.img"), 0444)).To(Succeed())
+			Expect(os.Chmod(rootFSDir, 0555)).To(Succeed())
+
+			err := CreateMinimalISO(filesDir, volumeID, testRootFSURL, "x86_64", minimalISOPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(filepath.Join(rootFSDir, "rootfs.img")).ToNot(BeAnExistingFile())
+		})
+
+		It("succeeds when the rootfs is already missing", func() {
+			Expect(os.Remove(filepath.Join(filesDir, "images/pxeboot/rootfs.img"))).To(Succeed())
+
+			err := CreateMinimalISO(filesDir, volumeID, testRootFSURL, "x86_64", minimalISOPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(minimalISOPath).To(BeAnExistingFile())
+		})
+
+		It("names the rootfs it failed to remove", func() {
+			rootFSPath := filepath.Join(filesDir, "images/pxeboot/rootfs.img")
+			Expect(os.Remove(rootFSPath)).To(Succeed())
+			Expect(os.MkdirAll(filepath.Join(rootFSPath, "nested"), 0755)).To(Succeed())
+
+			err := removeRootFS(rootFSPath)
+			Expect(err).To(MatchError(ContainSubstring("failed to remove rootfs " + rootFSPath)))
+		})
+	})
+
+	Describe("grub config discovery", func() {
+		It("edits a grub config in an unexpected directory", func() {
+			Expect(os.MkdirAll(filepath.Join(filesDir, "EFI/rebranded/boot"), 0755)).To(Succeed())
+			Expect(os.Rename(filepath.Join(filesDir, "EFI/redhat/grub.cfg"), filepath.Join(filesDir, "EFI/rebranded/boot/grub.cfg"))).To(Succeed())
+
+			var events []BuildEvent
+			eventsChan := make(chan BuildEvent, 100)
+			Expect(CreateMinimalISO(filesDir, volumeID, testRootFSURL, "aarch64", minimalISOPath, WithBuildEvents(eventsChan))).To(Succeed())
+			close(eventsChan)
+			for event := range eventsChan {
+				events = append(events, event)
+			}
+
+			validateMinimalISOFileContainsLine("EFI/rebranded/boot/grub.cfg", "	initrd /images/pxeboot/initrd.img /images/ignition.img "+ramDiskImagePath)
+			Expect(events[len(events)-1].GrubConfig).To(Equal("EFI/rebranded/boot/grub.cfg"))
+		})
+
+		It("reads the kernel arguments and rootfs url of a grub config in an unexpected directory", func() {
+			Expect(os.MkdirAll(filepath.Join(filesDir, "EFI/rebranded/boot"), 0755)).To(Succeed())
+			Expect(os.Rename(filepath.Join(filesDir, "EFI/redhat/grub.cfg"), filepath.Join(filesDir, "EFI/rebranded/boot/grub.cfg"))).To(Succeed())
+			// an isolinux config with other arguments would hide the grub config not being found
+			Expect(os.Remove(filepath.Join(filesDir, "isolinux/isolinux.cfg"))).To(Succeed())
+			Expect(CreateMinimalISO(filesDir, volumeID, testRootFSURL, "aarch64", minimalISOPath)).To(Succeed())
+
+			args, err := ReadKernelArgs(minimalISOPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(args).To(ContainElement("coreos.live.rootfs_url=" + testRootFSURL))
+			rootFSURL, err := GetRootFSURL(minimalISOPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rootFSURL).To(Equal(testRootFSURL))
+		})
+
+		It("prefers the known grub config paths", func() {
+			Expect(os.MkdirAll(filepath.Join(filesDir, "EFI/BOOT"), 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(filesDir, "EFI/BOOT/grub.cfg"), []byte(testGrubConfig), 0600)).To(Succeed())
+
+			grubPath, err := findGrubConfig(filesDir)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(grubPath).To(Equal(filepath.Join(filesDir, "EFI/redhat/grub.cfg")))
+		})
+	})
+
+	Describe("boot config warnings", func() {
+		It("warns when the isolinux config is missing on an arch using it", func() {
+			Expect(os.Remove(filepath.Join(filesDir, "isolinux/isolinux.cfg"))).To(Succeed())
+
+			warnings, err := fixIsolinuxConfig(testRootFSURL, filesDir, newMinimalISOOptions())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(warnings).To(HaveLen(1))
+			Expect(warnings[0].Kind).To(Equal(BuildWarningMissingConfig))
+			Expect(warnings[0].Path).To(Equal("isolinux/isolinux.cfg"))
+
+			events := make(chan BuildEvent, 100)
+			err = CreateMinimalISO(filesDir, volumeID, testRootFSURL, "x86_64", minimalISOPath, WithBuildEvents(events))
+			Expect(err).ToNot(HaveOccurred())
+			close(events)
+
+			var completed BuildEvent
+			for event := range events {
+				completed = event
+			}
+			Expect(completed.Type).To(Equal(BuildEventCompleted))
+			Expect(completed.Isolinux).To(BeFalse())
+			Expect(completed.Warnings).To(Equal(warnings))
+		})
+
+		It("warns about kernel arguments left with another value", func() {
+			grubPath := filepath.Join(filesDir, "EFI/redhat/grub.cfg")
+			grubContent := strings.Replace(testGrubConfig, "ignition.platform.id=metal", "ignition.platform.id=qemu", 1)
+			Expect(os.WriteFile(grubPath, []byte(grubContent), 0600)).To(Succeed())
+
+			warnings, err := fixGrubConfig(testRootFSURL, filesDir, newMinimalISOOptions())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(warnings).To(Equal([]BuildWarning{{
+				Kind:    BuildWarningKernelArgKept,
+				Path:    "EFI/redhat/grub.cfg",
+				Message: "kernel argument ignition.platform.id=metal is already set to another value and was left untouched",
+			}}))
+		})
+	})
+
+	Describe("concurrent edits", func() {
+		It("edits the boot configs of different extract dirs concurrently", func() {
+			const count = 8
+			var wg sync.WaitGroup
+			errs := make(chan error, 2*count)
+			for i := 0; i < count; i++ {
+				extractDir := filepath.Join(workDir, fmt.Sprintf("extract%d", i))
+				Expect(os.MkdirAll(filepath.Join(extractDir, "EFI/redhat"), 0755)).To(Succeed())
+				Expect(os.MkdirAll(filepath.Join(extractDir, "isolinux"), 0755)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(extractDir, "EFI/redhat/grub.cfg"), []byte(testGrubConfig), 0600)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(extractDir, "isolinux/isolinux.cfg"), []byte(testISOLinuxConfig), 0600)).To(Succeed())
+
+				wg.Add(1)
+				go func(i int, extractDir string) {
+					defer wg.Done()
+					rootFSURL := fmt.Sprintf("%s?build=%d", testRootFSURL, i)
+					o := newMinimalISOOptions()
+					_, err := fixGrubConfig(rootFSURL, extractDir, o)
+					errs <- err
+					_, err = fixIsolinuxConfig(rootFSURL, extractDir, o)
+					errs <- err
+				}(i, extractDir)
+			}
+			wg.Wait()
+			close(errs)
+			for err := range errs {
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			for i := 0; i < count; i++ {
+				extractDir := filepath.Join(workDir, fmt.Sprintf("extract%d", i))
+				rootFSArg := fmt.Sprintf("coreos.live.rootfs_url=%s?build=%d", testRootFSURL, i)
+				for _, config := range []string{"EFI/redhat/grub.cfg", "isolinux/isolinux.cfg"} {
+					content, err := os.ReadFile(filepath.Join(extractDir, config))
+					Expect(err).ToNot(HaveOccurred())
+					Expect(string(content)).To(ContainSubstring(rootFSArg))
+					Expect(strings.Count(string(content), "coreos.live.rootfs_url=")).To(Equal(1))
+				}
+			}
+		})
+	})
+
+	Describe("logger", func() {
+		It("logs the build failures to the given logger", func() {
+			Expect(os.Remove(filepath.Join(filesDir, "EFI/redhat/grub.cfg"))).To(Succeed())
+
+			var out bytes.Buffer
+			logger := logrus.New()
+			logger.SetOutput(&out)
+
+			err := CreateMinimalISO(filesDir, volumeID, testRootFSURL, "x86_64", minimalISOPath, WithLogger(logger.WithField("request", "test-request")))
+			Expect(err).To(HaveOccurred())
+			Expect(out.String()).To(ContainSubstring("Failed to edit grub config"))
+			Expect(out.String()).To(ContainSubstring("request=test-request"))
+		})
+
+		It("uses the given log field names", func() {
+			Expect(os.Remove(filepath.Join(filesDir, "EFI/redhat/grub.cfg"))).To(Succeed())
+			logger, hook := logtest.NewNullLogger()
+
+			err := CreateMinimalISO(filesDir, volumeID, testRootFSURL, "x86_64", minimalISOPath,
+				WithLogger(logger), WithLogFieldNames(LogFieldNames{Stage: "build.stage", WorkDir: "build.dir"}))
+			Expect(err).To(HaveOccurred())
+			entry := hook.LastEntry()
+			Expect(entry).ToNot(BeNil())
+			Expect(entry.Message).To(Equal("Failed to edit grub config"))
+			Expect(entry.Data).To(HaveKeyWithValue("build.stage", StageGrubConfig))
+			Expect(entry.Data).To(HaveKeyWithValue("build.dir", filesDir))
+			Expect(entry.Data).ToNot(HaveKey("stage"))
+		})
+	})
+
+	Describe("initrd placeholders", func() {
+		It("returns a typed error when the placeholder can't be created", func() {
+			// a file in place of the images directory makes creating the placeholder fail
+			extractDir := filepath.Join(workDir, "extract")
+			Expect(os.Mkdir(extractDir, 0755)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(extractDir, "images"), []byte("not a directory"), 0600)).To(Succeed())
+
+			err := embedInitrdPlaceholders(extractDir, nil, RamDiskPaddingLength, logrus.StandardLogger())
+			var placeholderErr *ErrPlaceholderCreate
+			Expect(errors.As(err, &placeholderErr)).To(BeTrue())
+			Expect(placeholderErr.Path).To(Equal(filepath.Join(extractDir, ramDiskImagePath)))
+			Expect(placeholderErr.Err).To(HaveOccurred())
+		})
+
+		It("embeds identical bytes for identical placeholder content", func() {
+			files := map[string][]byte{
+				"etc/assisted/first.conf":  []byte("first"),
+				"etc/assisted/second.conf": []byte("second"),
+			}
+			embed := func(name string) []byte {
+				extractDir := filepath.Join(workDir, name)
+				Expect(os.MkdirAll(filepath.Join(extractDir, "images"), 0755)).To(Succeed())
+				content, err := newMinimalISOOptions(WithPlaceholderFiles(files)).placeholderContent()
+				Expect(err).ToNot(HaveOccurred())
+				Expect(embedInitrdPlaceholders(extractDir, content, RamDiskPaddingLength, logrus.StandardLogger())).To(Succeed())
+				placeholder, err := os.ReadFile(filepath.Join(extractDir, ramDiskImagePath))
+				Expect(err).ToNot(HaveOccurred())
+				return placeholder
+			}
+
+			first := embed("first")
+			time.Sleep(1100 * time.Millisecond)
+			second := embed("second")
+			Expect(first).To(HaveLen(int(RamDiskPaddingLength)))
+			Expect(first).To(Equal(second))
+			Expect(first).ToNot(Equal(make([]byte, RamDiskPaddingLength)))
+		})
+
+		It("rejects placeholder content larger than the placeholder", func() {
+			random := make([]byte, RamDiskPaddingLength)
+			_, err := rand.Read(random)
+			Expect(err).ToNot(HaveOccurred())
+
+			err = CreateMinimalISO(filesDir, volumeID, testRootFSURL, "x86_64", minimalISOPath, WithPlaceholderFiles(map[string][]byte{"random": random}))
+			Expect(err).To(MatchError(ContainSubstring("the placeholder holds")))
+		})
+
+		It("creates a placeholder of the configured length", func() {
+			length := 2 * RamDiskPaddingLength
+			Expect(CreateMinimalISO(filesDir, volumeID, testRootFSURL, "x86_64", minimalISOPath, WithPlaceholderLength(length))).To(Succeed())
+
+			_, size, err := GetISOFileInfo(ramDiskImagePath, minimalISOPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(size).To(Equal(int64(length)))
+			Expect(VerifyPlaceholder(minimalISOPath, WithPlaceholderLength(length))).To(Succeed())
+			Expect(VerifyPlaceholder(minimalISOPath)).To(MatchError(ContainSubstring("expected 1048576")))
+		})
+
+		It("checks the placeholder content fits the configured length", func() {
+			random := make([]byte, 64*1024)
+			_, err := rand.Read(random)
+			Expect(err).ToNot(HaveOccurred())
+
+			err = CreateMinimalISO(filesDir, volumeID, testRootFSURL, "x86_64", minimalISOPath,
+				WithPlaceholderFiles(map[string][]byte{"random": random}), WithPlaceholderLength(32*1024))
+			Expect(err).To(MatchError(ContainSubstring("the placeholder holds 32768")))
+		})
+	})
+
+	Describe("non text configs", func() {
+		It("refuses to edit a compressed grub config", func() {
+			var compressed bytes.Buffer
+			w := gzip.NewWriter(&compressed)
+			_, err := w.Write([]byte(testGrubConfig))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(w.Close()).To(Succeed())
+			grubPath := filepath.Join(filesDir, "EFI/redhat/grub.cfg")
+			Expect(os.WriteFile(grubPath, compressed.Bytes(), 0600)).To(Succeed())
+
+			_, err = fixGrubConfig(testRootFSURL, filesDir, newMinimalISOOptions())
+			Expect(err).To(MatchError(ContainSubstring("grub.cfg is gzip compressed")))
+
+			content, err := os.ReadFile(grubPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(content).To(Equal(compressed.Bytes()))
+		})
+
+		It("refuses to edit a binary isolinux config", func() {
+			isolinuxPath := filepath.Join(filesDir, "isolinux/isolinux.cfg")
+			Expect(os.WriteFile(isolinuxPath, []byte{0x7f, 'E', 'L', 'F', 0, 0xff, 0xfe}, 0600)).To(Succeed())
+
+			_, err := fixIsolinuxConfig(testRootFSURL, filesDir, newMinimalISOOptions())
+			Expect(err).To(MatchError(ContainSubstring("isolinux.cfg is not a plain text file")))
+		})
+	})
+
+	Describe("grub config validation", func() {
+		It("detects an edit breaking the quoting", func() {
+			_, err := fixGrubConfig("https://example.com/it's-rootfs.img", filesDir, newMinimalISOOptions())
+			Expect(err).To(MatchError(ContainSubstring("unterminated ' quote on line 3")))
+		})
+
+		It("detects unbalanced braces", func() {
+			grubPath := filepath.Join(filesDir, "EFI/redhat/grub.cfg")
+			Expect(os.WriteFile(grubPath, []byte(strings.Replace(testGrubConfig, "\n}\n", "\n", 1)), 0600)).To(Succeed())
+
+			Expect(validateGrubConfig(grubPath)).To(MatchError(ContainSubstring("1 unclosed {")))
+		})
+
+		It("detects an initrd command with an invalid path", func() {
+			grubPath := filepath.Join(filesDir, "EFI/redhat/grub.cfg")
+			content := strings.Replace(testGrubConfig, "/images/ignition.img", "images/ignition.img", 1)
+			Expect(os.WriteFile(grubPath, []byte(content), 0600)).To(Succeed())
+
+			Expect(validateGrubConfig(grubPath)).To(MatchError(ContainSubstring(`invalid initrd path "images/ignition.img" on line 5`)))
+		})
+
+		It("fails when the config has no linux line to edit", func() {
+			grubPath := filepath.Join(filesDir, "EFI/redhat/grub.cfg")
+			content := strings.Replace(testGrubConfig, "\tlinux /images/pxeboot/vmlinuz", "\tlinuxefi /images/pxeboot/vmlinuz", 1)
+			Expect(os.WriteFile(grubPath, []byte(content), 0600)).To(Succeed())
+
+			_, err := fixGrubConfig(testRootFSURL, filesDir, newMinimalISOOptions())
+			Expect(err).To(MatchError(ContainSubstring("no linux line found in")))
+		})
+
+		It("fails when the config has no initrd line to edit", func() {
+			grubPath := filepath.Join(filesDir, "EFI/redhat/grub.cfg")
+			content := strings.Replace(testGrubConfig, "\tinitrd /images/pxeboot/initrd.img /images/ignition.img\n", "", 1)
+			Expect(os.WriteFile(grubPath, []byte(content), 0600)).To(Succeed())
+
+			_, err := fixGrubConfig(testRootFSURL, filesDir, newMinimalISOOptions())
+			Expect(err).To(MatchError(ContainSubstring("no initrd line found in")))
+		})
+
+		It("accepts the edited config", func() {
+			_, err := fixGrubConfig(testRootFSURL, filesDir, newMinimalISOOptions())
+			Expect(err).ToNot(HaveOccurred())
+			Expect(validateGrubConfig(filepath.Join(filesDir, "EFI/redhat/grub.cfg"))).To(Succeed())
+		})
+	})
+
+	Describe("isolinux config without append line", func() {
+		It("fails instead of leaving the config without the rootfs url", func() {
+			isolinuxPath := filepath.Join(filesDir, "isolinux/isolinux.cfg")
+			isolinuxContent := "default vesamenu.c32\ntimeout 600\n\nlabel linux\n  kernel /images/pxeboot/vmlinuz\n"
+			Expect(os.WriteFile(isolinuxPath, []byte(isolinuxContent), 0600)).To(Succeed())
+
+			_, err := fixIsolinuxConfig(testRootFSURL, filesDir, newMinimalISOOptions())
+			Expect(err).To(MatchError(ContainSubstring("no append line with an initrd found")))
+
+			content, err := os.ReadFile(isolinuxPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(content)).To(Equal(isolinuxContent))
+		})
+	})
+
+	Describe("ignition arguments", func() {
+		It("adds the missing ignition arguments next to the custom ramdisk image", func() {
+			grubPath := filepath.Join(filesDir, "EFI/redhat/grub.cfg")
+			grubContent := strings.Replace(testGrubConfig, " ignition.firstboot ignition.platform.id=metal", "", 1)
+			Expect(os.WriteFile(grubPath, []byte(grubContent), 0600)).To(Succeed())
+			isolinuxPath := filepath.Join(filesDir, "isolinux/isolinux.cfg")
+			isolinuxContent := strings.Replace(testISOLinuxConfig, " ignition.firstboot", "", 1)
+			Expect(os.WriteFile(isolinuxPath, []byte(isolinuxContent), 0600)).To(Succeed())
+
+			Expect(CreateMinimalISO(filesDir, volumeID, testRootFSURL, "x86_64", minimalISOPath)).To(Succeed())
+
+			grubCfg := fmt.Sprintf("	linux /images/pxeboot/vmlinuz random.trust_cpu=on rd.luks.options=discard 'coreos.live.rootfs_url=%s' ignition.firstboot ignition.platform.id=metal", testRootFSURL)
+			validateMinimalISOFileContainsLine("EFI/redhat/grub.cfg", grubCfg)
+			validateMinimalISOFileContainsLine("EFI/redhat/grub.cfg", fmt.Sprintf("	initrd /images/pxeboot/initrd.img /images/ignition.img %s", ramDiskImagePath))
+
+			isolinuxCfg := fmt.Sprintf("  append initrd=/images/pxeboot/initrd.img,/images/ignition.img,%s random.trust_cpu=on rd.luks.options=discard ignition.platform.id=metal coreos.live.rootfs_url=%s ignition.firstboot", ramDiskImagePath, testRootFSURL)
+			validateMinimalISOFileContainsLine("isolinux/isolinux.cfg", isolinuxCfg)
+		})
+
+		It("does not duplicate existing ignition arguments", func() {
+			Expect(CreateMinimalISO(filesDir, volumeID, testRootFSURL, "x86_64", minimalISOPath)).To(Succeed())
+
+			for _, cfg := range []string{"EFI/redhat/grub.cfg", "isolinux/isolinux.cfg"} {
+				content := readMinimalISOFile(cfg)
+				Expect(strings.Count(content, "ignition.firstboot")).To(Equal(1))
+				Expect(strings.Count(content, "ignition.platform.id=")).To(Equal(1))
+			}
+		})
+
+		It("sets the configured ignition platform id", func() {
+			grubPath := filepath.Join(filesDir, "EFI/redhat/grub.cfg")
+			grubContent := strings.Replace(testGrubConfig, " ignition.platform.id=metal", "", 1)
+			Expect(os.WriteFile(grubPath, []byte(grubContent), 0600)).To(Succeed())
+
+			warnings, err := fixGrubConfig(testRootFSURL, filesDir, newMinimalISOOptions(WithIgnitionPlatformID("openstack")))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(warnings).To(BeEmpty())
+
+			grubCfg := fmt.Sprintf("	linux /images/pxeboot/vmlinuz random.trust_cpu=on rd.luks.options=discard ignition.firstboot 'coreos.live.rootfs_url=%s' ignition.platform.id=openstack", testRootFSURL)
+			validateFileContainsLine(grubPath, grubCfg)
+		})
+
+		It("flags a conflicting ignition platform id", func() {
+			warnings, err := fixGrubConfig(testRootFSURL, filesDir, newMinimalISOOptions(WithIgnitionPlatformID("openstack")))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(warnings).To(HaveLen(1))
+			Expect(warnings[0].Kind).To(Equal(BuildWarningKernelArgKept))
+			Expect(warnings[0].Message).To(ContainSubstring("ignition.platform.id=openstack"))
+			validateFileContainsLine(filepath.Join(filesDir, "EFI/redhat/grub.cfg"), fmt.Sprintf("	linux /images/pxeboot/vmlinuz random.trust_cpu=on rd.luks.options=discard ignition.firstboot ignition.platform.id=metal 'coreos.live.rootfs_url=%s'", testRootFSURL))
+		})
+
+		It("rejects an invalid ignition platform id", func() {
+			err := CreateMinimalISO(filesDir, volumeID, testRootFSURL, "x86_64", minimalISOPath, WithIgnitionPlatformID("bare metal"))
+			Expect(err).To(MatchError(`invalid ignition platform id "bare metal"`))
+		})
+	})
+
+	DescribeTable("boot configs per arch",
+		func(arch string, editsIsolinux bool) {
+			Expect(CreateMinimalISO(filesDir, volumeID, testRootFSURL, arch, minimalISOPath)).To(Succeed())
+
+			grubCfg := readMinimalISOFile("EFI/redhat/grub.cfg")
+			Expect(grubCfg).To(ContainSubstring(testRootFSURL))
+
+			isolinuxCfg := readMinimalISOFile("isolinux/isolinux.cfg")
+			Expect(strings.Contains(isolinuxCfg, testRootFSURL)).To(Equal(editsIsolinux))
+		},
+		Entry("edits isolinux for x86_64", "x86_64", true),
+		Entry("skips isolinux for ppc64le", "ppc64le", false),
+		Entry("skips isolinux for aarch64", "aarch64", false),
+		Entry("skips isolinux for arm64", "arm64", false),
+	)
+
+	It("creates an aarch64 minimal iso without isolinux", func() {
+		// aarch64 ISOs only boot through EFI
+		Expect(os.RemoveAll(filepath.Join(filesDir, "isolinux"))).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(filesDir, "boot.catalog"), []byte(""), 0600)).To(Succeed())
+
+		Expect(CreateMinimalISO(filesDir, volumeID, testRootFSURL, "aarch64", minimalISOPath)).To(Succeed())
+
+		rootFSURL, err := GetRootFSURL(minimalISOPath)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(rootFSURL).To(Equal(testRootFSURL))
+	})
+
+	Describe("s390x", func() {
+		var s390xDir, s390xISO string
+
+		BeforeEach(func() {
+			s390xDir, s390xISO = createS390TestFiles(volumeID, 0)
+			prm := "rd.neednet=1 coreos.liveiso=rhcos-411.86.202210041459-0\nignition.firstboot ignition.platform.id=metal\n"
+			Expect(os.WriteFile(filepath.Join(s390xDir, "images/generic.prm"), []byte(prm), 0600)).To(Succeed())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(s390xDir)).To(Succeed())
+			Expect(os.Remove(s390xISO)).To(Succeed())
+		})
+
+		It("edits the parm files instead of grub and isolinux", func() {
+			events := make(chan BuildEvent, 100)
+			err := CreateMinimalISO(s390xDir, volumeID, testRootFSURL, "s390x", minimalISOPath, WithBuildEvents(events))
+			Expect(err).ToNot(HaveOccurred())
+			close(events)
+
+			var received []BuildEvent
+			for event := range events {
+				Expect(event.Stage).NotTo(Equal(StageGrubConfig))
+				Expect(event.Stage).NotTo(Equal(StageIsolinuxConfig))
+				received = append(received, event)
+			}
+			Expect(received).To(ContainElement(BuildEvent{Type: BuildEventStageFinished, Stage: StagePrmConfig}))
+
+			expected := fmt.Sprintf("rd.neednet=1 ignition.firstboot ignition.platform.id=metal coreos.live.rootfs_url=%s\n", testRootFSURL)
+			Expect(readMinimalISOFile("images/generic.prm")).To(Equal(expected))
+		})
+
+		It("fails without parm files", func() {
+			Expect(os.Remove(filepath.Join(s390xDir, "images/generic.prm"))).To(Succeed())
+
+			// the parm files are the only boot configs of the s390x test files
+			err := CreateMinimalISO(s390xDir, volumeID, testRootFSURL, "s390x", minimalISOPath)
+			var noConfigErr *ErrNoBootConfig
+			Expect(errors.As(err, &noConfigErr)).To(BeTrue())
+			Expect(noConfigErr.Paths).To(ContainElement("images/*.prm"))
+			Expect(fixPrmConfigs(testRootFSURL, s390xDir, newMinimalISOOptions())).To(MatchError(ContainSubstring("no parm file found")))
+		})
+	})
+
+	Describe("copy network argument", func() {
+		It("adds the argument to grub and isolinux when enabled", func() {
+			err := CreateMinimalISO(filesDir, volumeID, testRootFSURL, "x86_64", minimalISOPath, WithCopyNetworkArg("coreos.inst.copy_network"))
+			Expect(err).ToNot(HaveOccurred())
+
+			for _, cfg := range []string{"EFI/redhat/grub.cfg", "isolinux/isolinux.cfg"} {
+				content := readMinimalISOFile(cfg)
+				Expect(strings.Fields(content)).To(ContainElement("coreos.inst.copy_network"))
+			}
+		})
+
+		It("is not added by default", func() {
+			Expect(CreateMinimalISO(filesDir, volumeID, testRootFSURL, "x86_64", minimalISOPath)).To(Succeed())
+
+			content := readMinimalISOFile("EFI/redhat/grub.cfg")
+			Expect(content).NotTo(ContainSubstring("copy_network"))
+		})
+
+		It("fails with an unknown form", func() {
+			err := CreateMinimalISO(filesDir, volumeID, testRootFSURL, "x86_64", minimalISOPath, WithCopyNetworkArg("--copy-network"))
+			Expect(err).To(MatchError(ContainSubstring("unsupported copy network argument")))
+		})
+	})
+
+	Describe("kernel argument edits", func() {
+		kernelArgs := func(cfg string) []string {
+			return strings.Fields(readMinimalISOFile(cfg))
+		}
+
+		It("adds arguments to grub and isolinux", func() {
+			err := CreateMinimalISO(filesDir, volumeID, testRootFSURL, "x86_64", minimalISOPath,
+				WithAddedKernelArgs("ip=192.168.1.10::192.168.1.1:255.255.255.0::eth0:none", "rd.neednet=1"))
+			Expect(err).ToNot(HaveOccurred())
+
+			for _, cfg := range []string{"EFI/redhat/grub.cfg", "isolinux/isolinux.cfg"} {
+				Expect(kernelArgs(cfg)).To(ContainElements("ip=192.168.1.10::192.168.1.1:255.255.255.0::eth0:none", "rd.neednet=1"))
+			}
+		})
+
+		It("removes bare flags and key=value arguments", func() {
+			err := CreateMinimalISO(filesDir, volumeID, testRootFSURL, "x86_64", minimalISOPath,
+				WithRemovedKernelArgs("ignition.firstboot", "rd.luks.options=discard", "random.trust_cpu=off"))
+			Expect(err).ToNot(HaveOccurred())
+
+			for _, cfg := range []string{"EFI/redhat/grub.cfg", "isolinux/isolinux.cfg"} {
+				args := kernelArgs(cfg)
+				Expect(args).ToNot(ContainElements("ignition.firstboot", "rd.luks.options=discard"))
+				Expect(args).To(ContainElements("random.trust_cpu=on", "ignition.platform.id=metal"))
+			}
+		})
+
+		It("replaces arguments already present", func() {
+			for i := 0; i < 2; i++ {
+				options := newMinimalISOOptions(WithAddedKernelArgs("ignition.platform.id=qemu"))
+				_, err := fixGrubConfig(testRootFSURL, filesDir, options)
+				Expect(err).ToNot(HaveOccurred())
+				_, err = fixIsolinuxConfig(testRootFSURL, filesDir, options)
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			grubCfg := fmt.Sprintf("	linux /images/pxeboot/vmlinuz random.trust_cpu=on rd.luks.options=discard ignition.firstboot 'coreos.live.rootfs_url=%s' ignition.platform.id=qemu", testRootFSURL)
+			validateFileContainsLine(filepath.Join(filesDir, "EFI/redhat/grub.cfg"), grubCfg)
+			content, err := os.ReadFile(filepath.Join(filesDir, "isolinux/isolinux.cfg"))
+			Expect(err).ToNot(HaveOccurred())
+			isolinuxArgs := strings.Fields(string(content))
+			Expect(isolinuxArgs).To(ContainElement("ignition.platform.id=qemu"))
+			Expect(isolinuxArgs).ToNot(ContainElement("ignition.platform.id=metal"))
+		})
+
+		It("rejects editing the rootfs argument", func() {
+			err := CreateMinimalISO(filesDir, volumeID, testRootFSURL, "x86_64", minimalISOPath, WithRemovedKernelArgs(DefaultRootFSArgKey))
+			Expect(err).To(MatchError(ContainSubstring("can't be edited")))
+		})
+	})
+
+	Describe("repeated edits", func() {
+		It("replaces the rootfs url and custom ramdisk image of a previous edit", func() {
+			otherRootFSURL := strings.Replace(testRootFSURL, "4.7.7", "4.7.8", 1)
+			for _, rootFSURL := range []string{testRootFSURL, otherRootFSURL} {
+				_, err := fixGrubConfig(rootFSURL, filesDir, newMinimalISOOptions())
+				Expect(err).ToNot(HaveOccurred())
+				_, err = fixIsolinuxConfig(rootFSURL, filesDir, newMinimalISOOptions())
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			grubCfg := fmt.Sprintf("	linux /images/pxeboot/vmlinuz random.trust_cpu=on rd.luks.options=discard ignition.firstboot ignition.platform.id=metal 'coreos.live.rootfs_url=%s'", otherRootFSURL)
+			validateFileContainsLine(filepath.Join(filesDir, "EFI/redhat/grub.cfg"), grubCfg)
+			validateFileContainsLine(filepath.Join(filesDir, "EFI/redhat/grub.cfg"), "	initrd /images/pxeboot/initrd.img /images/ignition.img "+ramDiskImagePath)
+			isolinuxCfg := fmt.Sprintf("  append initrd=/images/pxeboot/initrd.img,/images/ignition.img,%s random.trust_cpu=on rd.luks.options=discard ignition.firstboot ignition.platform.id=metal coreos.live.rootfs_url=%s", ramDiskImagePath, otherRootFSURL)
+			validateFileContainsLine(filepath.Join(filesDir, "isolinux/isolinux.cfg"), isolinuxCfg)
+		})
+	})
+
+	Describe("initrd position", func() {
+		It("inserts the custom ramdisk image before a named initrd image", func() {
+			o := newMinimalISOOptions(WithInitrdPosition(InitrdPosition{Image: "ignition.img"}))
+			_, err := fixGrubConfig(testRootFSURL, filesDir, o)
+			Expect(err).ToNot(HaveOccurred())
+			_, err = fixIsolinuxConfig(testRootFSURL, filesDir, o)
+			Expect(err).ToNot(HaveOccurred())
+
+			validateFileContainsLine(filepath.Join(filesDir, "EFI/redhat/grub.cfg"), "	initrd /images/pxeboot/initrd.img "+ramDiskImagePath+" /images/ignition.img")
+			isolinuxCfg, err := os.ReadFile(filepath.Join(filesDir, "isolinux/isolinux.cfg"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(string(isolinuxCfg)).To(ContainSubstring("initrd=/images/pxeboot/initrd.img," + ramDiskImagePath + ",/images/ignition.img "))
+		})
+
+		It("inserts the custom ramdisk image at an index", func() {
+			_, err := fixGrubConfig(testRootFSURL, filesDir, newMinimalISOOptions(WithInitrdPosition(InitrdPosition{Index: 0})))
+			Expect(err).ToNot(HaveOccurred())
+
+			validateFileContainsLine(filepath.Join(filesDir, "EFI/redhat/grub.cfg"), "	initrd "+ramDiskImagePath+" /images/pxeboot/initrd.img /images/ignition.img")
+		})
+
+		It("fails for a missing initrd image", func() {
+			_, err := fixGrubConfig(testRootFSURL, filesDir, newMinimalISOOptions(WithInitrdPosition(InitrdPosition{Image: "missing.img"})))
+			Expect(err).To(MatchError(ContainSubstring(`initrd image "missing.img" not found`)))
+		})
+	})
+
+	Describe("extra initrd images", func() {
+		extraImages := []string{"/images/static_network.img", "/images/extra.img"}
+
+		BeforeEach(func() {
+			for _, image := range extraImages {
+				Expect(os.WriteFile(filepath.Join(filesDir, image), []byte("image"), 0600)).To(Succeed())
+			}
+		})
+
+		It("adds the images in order after the custom ramdisk image", func() {
+			Expect(CreateMinimalISO(filesDir, volumeID, testRootFSURL, "x86_64", minimalISOPath, WithExtraInitrdImages(extraImages...))).To(Succeed())
+
+			validateMinimalISOFileContainsLine("EFI/redhat/grub.cfg",
+				"	initrd /images/pxeboot/initrd.img /images/ignition.img "+ramDiskImagePath+" /images/static_network.img /images/extra.img")
+			Expect(readMinimalISOFile("isolinux/isolinux.cfg")).To(ContainSubstring("initrd=/images/pxeboot/initrd.img,/images/ignition.img," + ramDiskImagePath + ",/images/static_network.img,/images/extra.img "))
+		})
+
+		It("adds the images only once when the configs are edited again", func() {
+			for i := 0; i < 2; i++ {
+				options := newMinimalISOOptions(WithExtraInitrdImages(extraImages...))
+				_, err := fixGrubConfig(testRootFSURL, filesDir, options)
+				Expect(err).ToNot(HaveOccurred())
+				_, err = fixIsolinuxConfig(testRootFSURL, filesDir, options)
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			validateFileContainsLine(filepath.Join(filesDir, "EFI/redhat/grub.cfg"),
+				"	initrd /images/pxeboot/initrd.img /images/ignition.img "+ramDiskImagePath+" /images/static_network.img /images/extra.img")
+			isolinuxCfg, err := os.ReadFile(filepath.Join(filesDir, "isolinux/isolinux.cfg"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(strings.Count(string(isolinuxCfg), "/images/extra.img")).To(Equal(1))
+		})
+
+		It("fails for an image missing from the extract dir", func() {
+			err := CreateMinimalISO(filesDir, volumeID, testRootFSURL, "x86_64", minimalISOPath, WithExtraInitrdImages("/images/missing.img"))
+			Expect(err).To(MatchError(ContainSubstring("extra initrd image /images/missing.img not found")))
+		})
+
+		It("rejects invalid image paths", func() {
+			err := CreateMinimalISO(filesDir, volumeID, testRootFSURL, "x86_64", minimalISOPath, WithExtraInitrdImages("images/a.img,b.img"))
+			Expect(err).To(MatchError(`invalid extra initrd image path "images/a.img,b.img"`))
+		})
+	})
+
+	Describe("rootfs URL validation", func() {
+		DescribeTable("rejects invalid URLs",
+			func(rootFSURL, message string) {
+				err := CreateMinimalISO(filesDir, volumeID, rootFSURL, "x86_64", minimalISOPath)
+				Expect(err).To(MatchError(ContainSubstring(message)))
+				Expect(minimalISOPath).ToNot(BeAnExistingFile())
+			},
+			Entry("whitespace", "https://example.com/rhcos live rootfs.img", "contains whitespace"),
+			Entry("unsupported scheme", "ftp://example.com/rootfs.img", "scheme must be one of [http https]"),
+			Entry("no scheme", "example.com/rootfs.img", "scheme must be one of"),
+			Entry("missing host", "https:///rootfs.img", "missing host"),
+			Entry("unparsable", "https://example.com:port/rootfs.img", "invalid rootfs URL"),
+		)
+
+		It("fails before extracting the iso", func() {
+			editor := NewEditor(workDir)
+			err := editor.CreateMinimalISOTemplate(context.Background(), isoFile, "tftp://example.com/rootfs.img", "x86_64", minimalISOPath)
+			Expect(err).To(MatchError(ContainSubstring("scheme must be one of")))
+
+			entries, err := os.ReadDir(workDir)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entries).To(BeEmpty())
+		})
+
+		It("accepts additional schemes", func() {
+			rootFSURL := "file:///var/lib/rootfs.img"
+			err := CreateMinimalISO(filesDir, volumeID, rootFSURL, "x86_64", minimalISOPath, WithAdditionalRootFSURLSchemes("file", "tftp"))
+			Expect(err).ToNot(HaveOccurred())
+
+			url, err := GetRootFSURL(minimalISOPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(url).To(Equal(rootFSURL))
+		})
+
+		It("accepts a custom set of schemes", func() {
+			opt := WithRootFSURLSchemes("installer")
+			err := CreateMinimalISO(filesDir, volumeID, testRootFSURL, "x86_64", minimalISOPath, opt)
+			Expect(err).To(MatchError(ContainSubstring("scheme must be one of [installer]")))
+			err = CreateMinimalISO(filesDir, volumeID, "installer:", "x86_64", minimalISOPath, opt)
+			Expect(err).To(MatchError(ContainSubstring("missing host and path")))
+
+			rootFSURL := "installer://rootfs/rhcos-live-rootfs.x86_64.img"
+			Expect(CreateMinimalISO(filesDir, volumeID, rootFSURL, "x86_64", minimalISOPath, opt)).To(Succeed())
+			url, err := GetRootFSURL(minimalISOPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(url).To(Equal(rootFSURL))
+		})
+	})
+
+	Describe("rootfs argument key", func() {
+		It("uses the default key", func() {
+			Expect(CreateMinimalISO(filesDir, volumeID, testRootFSURL, "x86_64", minimalISOPath)).To(Succeed())
+
+			rootFSURL, err := GetRootFSURL(minimalISOPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rootFSURL).To(Equal(testRootFSURL))
+		})
+
+		It("uses a custom key in grub and isolinux", func() {
+			err := CreateMinimalISO(filesDir, volumeID, testRootFSURL, "x86_64", minimalISOPath, WithRootFSArgKey("inst.rootfs"))
+			Expect(err).ToNot(HaveOccurred())
+
+			grubCfg := fmt.Sprintf("	linux /images/pxeboot/vmlinuz random.trust_cpu=on rd.luks.options=discard ignition.firstboot ignition.platform.id=metal 'inst.rootfs=%s'", testRootFSURL)
+			validateMinimalISOFileContainsLine("EFI/redhat/grub.cfg", grubCfg)
+			isolinuxCfg := fmt.Sprintf("  append initrd=/images/pxeboot/initrd.img,/images/ignition.img,%s random.trust_cpu=on rd.luks.options=discard ignition.firstboot ignition.platform.id=metal inst.rootfs=%s", ramDiskImagePath, testRootFSURL)
+			validateMinimalISOFileContainsLine("isolinux/isolinux.cfg", isolinuxCfg)
+
+			rootFSURL, err := GetRootFSURL(minimalISOPath, WithRootFSArgKey("inst.rootfs"))
+			Expect(err).ToNot(HaveOccurred())
+			Expect(rootFSURL).To(Equal(testRootFSURL))
+
+			_, err = GetRootFSURL(minimalISOPath)
+			Expect(err).To(MatchError(ContainSubstring("no coreos.live.rootfs_url argument found")))
+		})
+	})
+
+	Describe("moveFile", func() {
+		var (
+			src     string
+			dstDir  string
+			dst     string
+			content = bytes.Repeat([]byte("minimal iso "), 100000)
+		)
+
+		BeforeEach(func() {
+			src = filepath.Join(workDir, "minimal.iso.tmp")
+			Expect(os.WriteFile(src, content, 0644)).To(Succeed())
+			dstDir = filepath.Join(workDir, "output")
+			Expect(os.Mkdir(dstDir, 0755)).To(Succeed())
+			dst = filepath.Join(dstDir, "minimal.iso")
+		})
+
+		// crossDeviceRename fails like os.Rename does across devices for src only
+		crossDeviceRename := func(oldpath, newpath string) error {
+			if oldpath == src {
+				return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EXDEV}
+			}
+			return os.Rename(oldpath, newpath)
+		}
+
+		It("copies the file across devices", func() {
+			Expect(moveFile(src, dst, crossDeviceRename)).To(Succeed())
+
+			moved, err := os.ReadFile(dst)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(moved).To(Equal(content))
+			info, err := os.Stat(dst)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(info.Mode().Perm()).To(Equal(os.FileMode(0644)))
+			Expect(src).ToNot(BeAnExistingFile())
+			entries, err := os.ReadDir(dstDir)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entries).To(HaveLen(1))
+		})
+
+		It("leaves no partial file when the copy can't be renamed", func() {
+			err := moveFile(src, dst, func(oldpath, newpath string) error {
+				if oldpath == src {
+					return crossDeviceRename(oldpath, newpath)
+				}
+				return errors.New("rename failed")
+			})
+			Expect(err).To(MatchError("rename failed"))
+
+			Expect(src).To(BeAnExistingFile())
+			entries, err := os.ReadDir(dstDir)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(entries).To(BeEmpty())
+		})
+
+		It("returns other rename errors", func() {
+			err := moveFile(src, dst, func(oldpath, newpath string) error {
+				return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EACCES}
+			})
+			Expect(errors.Is(err, syscall.EACCES)).To(BeTrue())
+			Expect(src).To(BeAnExistingFile())
+		})
+	})
+
+	Describe("ReadKernelArgs", func() {
+		It("reads the grub kernel arguments", func() {
+			args, err := ReadKernelArgs(isoFile)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(args).To(Equal([]string{"random.trust_cpu=on", "rd.luks.options=discard", "coreos.liveiso=rhcos-46.82.202010091720-0", "ignition.firstboot", "ignition.platform.id=metal"}))
+		})
+
+		It("unquotes the arguments of a minimal iso", func() {
+			Expect(CreateMinimalISO(filesDir, volumeID, testRootFSURL, "x86_64", minimalISOPath)).To(Succeed())
+
+			args, err := ReadKernelArgs(minimalISOPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(args).To(ContainElement("coreos.live.rootfs_url=" + testRootFSURL))
+			Expect(args).ToNot(ContainElement(HavePrefix("coreos.liveiso")))
+		})
+
+		It("falls back to the isolinux config", func() {
+			Expect(os.Remove(filepath.Join(filesDir, "EFI/redhat/grub.cfg"))).To(Succeed())
+			isoPath := filepath.Join(workDir, "isolinux.iso")
+			Expect(Create(isoPath, filesDir, volumeID)).To(Succeed())
+
+			args, err := ReadKernelArgs(isoPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(args).To(Equal([]string{"initrd=/images/pxeboot/initrd.img,/images/ignition.img", "random.trust_cpu=on", "rd.luks.options=discard", "coreos.liveiso=rhcos-46.82.202010091720-0", "ignition.firstboot", "ignition.platform.id=metal"}))
+		})
+
+		It("returns a typed error when the iso has no boot config", func() {
+			Expect(os.Remove(filepath.Join(filesDir, "EFI/redhat/grub.cfg"))).To(Succeed())
+			Expect(os.Remove(filepath.Join(filesDir, "isolinux/isolinux.cfg"))).To(Succeed())
+			isoPath := filepath.Join(workDir, "noconfig.iso")
+			Expect(Create(isoPath, filesDir, volumeID)).To(Succeed())
+
+			_, err := ReadKernelArgs(isoPath)
+			var noConfigErr *ErrNoBootConfig
+			Expect(errors.As(err, &noConfigErr)).To(BeTrue())
+			Expect(noConfigErr.Paths).To(ContainElement("isolinux/isolinux.cfg"))
+		})
+
+		It("returns the error of a boot config it can't read", func() {
+			_, err := readKernelArgs(isoFile, func(isoPath, internalPath string) ([]byte, error) {
+				return nil, syscall.EIO
+			})
+			Expect(errors.Is(err, syscall.EIO)).To(BeTrue())
+		})
+	})
+
+	Describe("ValidateISO", func() {
+		It("accepts an RHCOS live iso", func() {
+			Expect(ValidateISO(isoFile)).To(Succeed())
+		})
+
+		It("lists what a non-RHCOS iso is missing", func() {
+			Expect(os.Remove(filepath.Join(filesDir, "images/pxeboot/rootfs.img"))).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(filesDir, "EFI/redhat/grub.cfg"), []byte("linux /vmlinuz quiet\n"), 0600)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(filesDir, "isolinux/isolinux.cfg"), []byte("  append quiet\n"), 0600)).To(Succeed())
+			isoPath := filepath.Join(workDir, "generic.iso")
+			Expect(Create(isoPath, filesDir, volumeID)).To(Succeed())
+
+			err := ValidateISO(isoPath)
+			var invalidErr *ErrInvalidISO
+			Expect(errors.As(err, &invalidErr)).To(BeTrue())
+			Expect(invalidErr.Path).To(Equal(isoPath))
+			Expect(invalidErr.Missing).To(Equal([]string{"images/pxeboot/rootfs.img", "coreos.liveiso kernel argument"}))
+		})
+
+		It("fails for a file that isn't an iso", func() {
+			notISO := filepath.Join(workDir, "truncated.iso")
+			Expect(os.WriteFile(notISO, []byte("truncated"), 0600)).To(Succeed())
+			Expect(ValidateISO(notISO)).ToNot(Succeed())
+		})
+	})
+
+	Describe("rootfs device label", func() {
+		It("points grub and isolinux at the local device", func() {
+			err := CreateMinimalISO(filesDir, volumeID, testRootFSURL, "x86_64", minimalISOPath, WithRootFSDeviceLabel("rhcos-data"))
+			Expect(err).ToNot(HaveOccurred())
+
+			grubCfg := "	linux /images/pxeboot/vmlinuz random.trust_cpu=on rd.luks.options=discard ignition.firstboot ignition.platform.id=metal 'coreos.liveiso=rhcos-data'"
+			validateMinimalISOFileContainsLine("EFI/redhat/grub.cfg", grubCfg)
+
+			isolinuxCfg := fmt.Sprintf("  append initrd=/images/pxeboot/initrd.img,/images/ignition.img,%s random.trust_cpu=on rd.luks.options=discard ignition.firstboot ignition.platform.id=metal coreos.liveiso=rhcos-data", ramDiskImagePath)
+			validateMinimalISOFileContainsLine("isolinux/isolinux.cfg", isolinuxCfg)
+
+			for _, cfg := range []string{"EFI/redhat/grub.cfg", "isolinux/isolinux.cfg"} {
+				Expect(readMinimalISOFile(cfg)).ToNot(ContainSubstring("coreos.live.rootfs_url"))
+			}
+		})
+
+		It("replaces the label of a previous edit", func() {
+			for _, label := range []string{"rhcos-old", "rhcos-data"} {
+				options := newMinimalISOOptions(WithRootFSDeviceLabel(label))
+				_, err := fixGrubConfig(testRootFSURL, filesDir, options)
+				Expect(err).ToNot(HaveOccurred())
+				_, err = fixIsolinuxConfig(testRootFSURL, filesDir, options)
+				Expect(err).ToNot(HaveOccurred())
+			}
+
+			for _, cfg := range []string{"EFI/redhat/grub.cfg", "isolinux/isolinux.cfg"} {
+				content, err := os.ReadFile(filepath.Join(filesDir, cfg))
+				Expect(err).ToNot(HaveOccurred())
+				Expect(strings.Count(string(content), "coreos.liveiso=")).To(Equal(1))
+				Expect(string(content)).To(ContainSubstring("coreos.liveiso=rhcos-data"))
+			}
+		})
+
+		It("fails with an invalid label", func() {
+			err := CreateMinimalISO(filesDir, volumeID, testRootFSURL, "x86_64", minimalISOPath, WithRootFSDeviceLabel("rhcos data"))
+			Expect(err).To(MatchError(ContainSubstring("invalid rootfs device label")))
+		})
+	})
+
+	Describe("embedded rootfs", func() {
+		It("keeps the rootfs and boots from the iso", func() {
+			err := CreateMinimalISO(filesDir, volumeID, "", "x86_64", minimalISOPath, WithEmbeddedRootFS())
+			Expect(err).ToNot(HaveOccurred())
+
+			_, _, err = GetISOFileInfo("/images/pxeboot/rootfs.img", minimalISOPath)
+			Expect(err).ToNot(HaveOccurred())
+
+			for _, cfg := range []string{"EFI/redhat/grub.cfg", "isolinux/isolinux.cfg"} {
+				content := readMinimalISOFile(cfg)
+				Expect(content).ToNot(ContainSubstring("coreos.live.rootfs_url"))
+				Expect(content).To(ContainSubstring("coreos.liveiso="))
+				Expect(content).To(ContainSubstring(ramDiskImagePath))
+			}
+		})
+
+		It("fails when combined with a volume identifier override", func() {
+			err := CreateMinimalISO(filesDir, volumeID, "", "x86_64", minimalISOPath, WithEmbeddedRootFS(), WithVolumeID("OFFLINE"))
+			Expect(err).To(MatchError(ContainSubstring("embedded rootfs")))
+		})
+	})
+
+	Describe("boot timeouts", func() {
+		timeouts := map[string]time.Duration{
+			"x86_64":  5 * time.Second,
+			"ppc64le": 20 * time.Second,
+		}
+
+		It("sets the timeout for the arch in grub and isolinux", func() {
+			err := CreateMinimalISO(filesDir, volumeID, testRootFSURL, "x86_64", minimalISOPath, WithBootTimeouts(timeouts))
+			Expect(err).ToNot(HaveOccurred())
+
+			validateMinimalISOFileContainsLine("EFI/redhat/grub.cfg", "set timeout=5")
+			validateMinimalISOFileContainsLine("isolinux/isolinux.cfg", "timeout 50")
+		})
+
+		It("sets only the grub timeout for ppc64le", func() {
+			err := CreateMinimalISO(filesDir, volumeID, testRootFSURL, "ppc64le", minimalISOPath, WithBootTimeouts(timeouts))
+			Expect(err).ToNot(HaveOccurred())
+
+			validateMinimalISOFileContainsLine("EFI/redhat/grub.cfg", "set timeout=20")
+			Expect(readMinimalISOFile("isolinux/isolinux.cfg")).To(Equal(testISOLinuxConfig))
+		})
+
+		It("replaces an existing timeout", func() {
+			grubPath := filepath.Join(filesDir, "EFI/redhat/grub.cfg")
+			Expect(os.WriteFile(grubPath, []byte("set timeout=60\n"+testGrubConfig), 0600)).To(Succeed())
+
+			Expect(setGrubTimeout(filesDir, 5*time.Second)).To(Succeed())
+
+			content, err := os.ReadFile(grubPath)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(strings.Count(string(content), "set timeout=")).To(Equal(1))
+			validateFileContainsLine(grubPath, "set timeout=5")
+		})
+
+		It("keeps the timeouts for an arch without a configured timeout", func() {
+			err := CreateMinimalISO(filesDir, volumeID, testRootFSURL, "x86_64", minimalISOPath, WithBootTimeouts(map[string]time.Duration{"arm64": time.Second}))
+			Expect(err).ToNot(HaveOccurred())
+
+			Expect(readMinimalISOFile("EFI/redhat/grub.cfg")).ToNot(ContainSubstring("set timeout="))
+		})
+	})
+})
+
+// slowReaderAt delays each read
+type slowReaderAt struct {
+	io.ReaderAt
+	delay time.Duration
+	reads int
+}
+
+func (r *slowReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	r.reads++
+	time.Sleep(r.delay)
+	return r.ReaderAt.ReadAt(p, off)
+}